@@ -0,0 +1,196 @@
+// Copyright 2020 The covid19 Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sbinet/covid19/data"
+	"github.com/sbinet/covid19/internal/logctx"
+)
+
+// Event is a dated policy event of a country, such as a lockdown.
+type Event struct {
+	Date  time.Time
+	Type  string // kind of event, one of eventTypes
+	Label string
+}
+
+// eventTypes holds the kinds of policy events.
+var eventTypes = map[string]bool{
+	"lockdown":     true,
+	"reopening":    true,
+	"mask-mandate": true,
+	"curfew":       true,
+}
+
+//go:embed annotations.csv
+var defaultAnnotations []byte
+
+// annotations holds the policy events of each country, in chronological
+// order. The first lockdown is used as day 0 when anchoring on lockdowns.
+var annotations = mustParseAnnotations(defaultAnnotations)
+
+func mustParseAnnotations(raw []byte) map[string][]Event {
+	events, err := parseAnnotations(bytes.NewReader(raw))
+	if err != nil {
+		panic(fmt.Errorf("could not parse embedded annotations: %w", err))
+	}
+	return events
+}
+
+// loadAnnotations reads the policy events stored in the CSV file fname.
+func loadAnnotations(fname string) (map[string][]Event, error) {
+	f, err := os.Open(fname)
+	if err != nil {
+		return nil, fmt.Errorf("could not open annotations file: %w", err)
+	}
+	defer f.Close()
+
+	return parseAnnotations(f)
+}
+
+// parseAnnotations parses the policy events from r.
+// Each record holds a country, a date (2006-01-02), the type of the event
+// and the label drawn on the plots.
+func parseAnnotations(r io.Reader) (map[string][]Event, error) {
+	raw := csv.NewReader(r)
+	raw.Comment = '#'
+	raw.FieldsPerRecord = 4
+
+	hdr, err := raw.Read()
+	if err != nil {
+		return nil, fmt.Errorf("could not read annotations header: %w", err)
+	}
+	if want := "country,date,type,label"; strings.Join(hdr, ",") != want {
+		return nil, fmt.Errorf("invalid annotations header %q (want %q)", strings.Join(hdr, ","), want)
+	}
+
+	events := make(map[string][]Event)
+	for {
+		rec, err := raw.Read()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, fmt.Errorf("could not read annotations: %w", err)
+		}
+		name := data.CanonicalName(rec[0])
+		if name == "" {
+			return nil, fmt.Errorf("invalid annotations country %q", rec[0])
+		}
+		date, err := time.Parse("2006-01-02", rec[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid annotations date %q: %w", rec[1], err)
+		}
+		if !eventTypes[rec[2]] {
+			return nil, fmt.Errorf("invalid annotations type %q", rec[2])
+		}
+		label := rec[3]
+		if label == "" {
+			label = rec[2]
+		}
+		events[name] = append(events[name], Event{
+			Date:  date,
+			Type:  rec[2],
+			Label: label,
+		})
+	}
+
+	for _, evs := range events {
+		sort.SliceStable(evs, func(i, j int) bool {
+			return evs[i].Date.Before(evs[j].Date)
+		})
+	}
+	return events, nil
+}
+
+// firstLockdown returns the first lockdown of a country.
+func firstLockdown(name string) (Event, bool) {
+	for _, ev := range annotations[name] {
+		if ev.Type == "lockdown" {
+			return ev, true
+		}
+	}
+	return Event{}, false
+}
+
+// annotationResponse is the JSON representation of a policy event.
+type annotationResponse struct {
+	Country string `json:"country"`
+	Date    string `json:"date"`
+	Type    string `json:"type"`
+	Label   string `json:"label"`
+}
+
+// annotationsHandle serves the policy events drawn on the plots as JSON,
+// optionally restricted to the countries and types of events requested
+// with the "countries" and "type" query parameters.
+func annotationsHandle(w http.ResponseWriter, req *http.Request) {
+	ctx := req.Context()
+
+	var names []string
+	if v := req.FormValue("countries"); v != "" {
+		for _, name := range strings.Split(v, ",") {
+			name = data.CanonicalName(name)
+			if name == "" {
+				continue
+			}
+			names = append(names, name)
+		}
+		if len(names) == 0 {
+			http.Error(w, fmt.Sprintf("invalid countries value %q", v), http.StatusBadRequest)
+			return
+		}
+	} else {
+		for name := range annotations {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+	}
+
+	types := make(map[string]bool)
+	if v := req.FormValue("type"); v != "" {
+		for _, typ := range strings.Split(v, ",") {
+			if !eventTypes[typ] {
+				http.Error(w, fmt.Sprintf("invalid type value %q", typ), http.StatusBadRequest)
+				return
+			}
+			types[typ] = true
+		}
+	}
+
+	resp := make([]annotationResponse, 0)
+	for _, name := range names {
+		for _, ev := range annotations[name] {
+			if len(types) > 0 && !types[ev.Type] {
+				continue
+			}
+			resp = append(resp, annotationResponse{
+				Country: name,
+				Date:    ev.Date.Format("2006-01-02"),
+				Type:    ev.Type,
+				Label:   ev.Label,
+			})
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(resp)
+	if err != nil {
+		logctx.From(ctx).Error("could not encode JSON response", "error", err)
+		return
+	}
+}
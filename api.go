@@ -0,0 +1,343 @@
+// Copyright 2020 The covid19 Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/sbinet/covid19/data"
+	"github.com/sbinet/covid19/internal/logctx"
+)
+
+// dataHandle serves the cutoff-aligned time series as JSON.
+// It is also served as /api/v1/series, where the dataset may be
+// selected with the "metric" query parameter.
+func dataHandle(w http.ResponseWriter, req *http.Request) {
+	ctx := req.Context()
+	title, err := parseMetric(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	opts, err := parseOptions(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	cutoff := opts.cutoff(cutoffs[title])
+	ds, err := data.Fetch(ctx, title, cutoff, opts.Countries, opts.Options)
+	if err != nil {
+		logctx.From(ctx).Error("could not serve request", "title", title, "error", err)
+		http.Error(w, err.Error(), errStatus(err))
+		return
+	}
+	if notModified(w, req, ds.Date, "json") {
+		return
+	}
+
+	prec := titlePrec(title)
+	resp := dataResponse{
+		Title:     title,
+		Start:     ds.Start.Format("2006-01-02"),
+		Date:      ds.Date.Format("2006-01-02"),
+		Cutoff:    cutoff,
+		Countries: make(map[string]seriesResponse, len(opts.Countries)),
+	}
+	for _, name := range opts.Countries {
+		var (
+			series seriesResponse
+			offset int // untrimmed series start on the first day of the data.
+		)
+		if idx, ok := ds.Cutoff[name]; ok {
+			series.Offset = &idx
+			offset = idx
+		}
+		series.Dates = make([]string, len(ds.Table[name]))
+		series.Values = make([]jsonFloat, len(ds.Table[name]))
+		for i, v := range ds.Table[name] {
+			series.Dates[i] = ds.Start.AddDate(0, 0, offset+i).Format("2006-01-02")
+			series.Values[i] = jsonFloat(opts.round(v, prec))
+		}
+		resp.Countries[name] = series
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(resp)
+	if err != nil {
+		logctx.From(ctx).Error("could not encode JSON response", "title", title, "error", err)
+		return
+	}
+}
+
+// csvHandle serves the cutoff-aligned time series as a CSV table,
+// with one row per day since the cutoff and one column per country that
+// reached it.
+func csvHandle(title string, cutoff float64) func(w http.ResponseWriter, req *http.Request) {
+	return func(w http.ResponseWriter, req *http.Request) {
+		ctx := req.Context()
+		opts, err := parseOptions(req)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		cutoff := opts.cutoff(cutoff)
+		ds, err := data.Fetch(ctx, title, cutoff, opts.Countries, opts.Options)
+		if err != nil {
+			logctx.From(ctx).Error("could not serve request", "title", title, "error", err)
+			http.Error(w, err.Error(), errStatus(err))
+			return
+		}
+		if notModified(w, req, ds.Date, "csv") {
+			return
+		}
+
+		// as in the plots, the series that never reached the cutoff are
+		// left out, as they are not aligned with the others.
+		var countries []string
+		for _, name := range opts.Countries {
+			if !ds.Reached(name) {
+				logctx.From(ctx).Warn("cutoff never reached, skipping", "title", title, "country", name, "cutoff", cutoff)
+				continue
+			}
+			countries = append(countries, name)
+		}
+
+		rows := 0
+		for _, name := range countries {
+			if n := len(ds.Table[name]); n > rows {
+				rows = n
+			}
+		}
+
+		w.Header().Set("Content-Type", "text/csv")
+		w.Header().Set("Content-Disposition", fmt.Sprintf(
+			"attachment; filename=covid-%s-%s.csv", title, ds.Date.Format("2006-01-02"),
+		))
+
+		prec := titlePrec(title)
+		out := csv.NewWriter(w)
+		rec := make([]string, 1+len(countries))
+		rec[0] = "day"
+		copy(rec[1:], countries)
+		_ = out.Write(rec)
+		for i := 0; i < rows; i++ {
+			rec[0] = strconv.Itoa(i)
+			for j, name := range countries {
+				rec[j+1] = ""
+				ys := ds.Table[name]
+				if i >= len(ys) || math.IsNaN(ys[i]) {
+					continue
+				}
+				rec[j+1] = opts.format(ys[i], prec)
+			}
+			_ = out.Write(rec)
+		}
+		out.Flush()
+		if err := out.Error(); err != nil {
+			logctx.From(ctx).Error("could not write CSV response", "title", title, "error", err)
+			return
+		}
+	}
+}
+
+// countriesHandle serves the sorted list of the countries (or US states)
+// present in a dataset, as JSON.
+func countriesHandle(w http.ResponseWriter, req *http.Request) {
+	ctx := req.Context()
+	title, err := parseTitle(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	opts, err := parseOptions(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ds, err := data.Fetch(ctx, title, 0, nil, opts.Options)
+	if err != nil {
+		logctx.From(ctx).Error("could not serve request", "title", title, "error", err)
+		http.Error(w, err.Error(), errStatus(err))
+		return
+	}
+	if notModified(w, req, ds.Date, "json") {
+		return
+	}
+
+	names := make([]string, 0, len(ds.Table))
+	for name := range ds.Table {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(names)
+	if err != nil {
+		logctx.From(ctx).Error("could not encode JSON response", "title", title, "error", err)
+		return
+	}
+}
+
+// countryListHandle serves the sorted list of the countries (or US states)
+// present in a dataset, together with the regions summed into each of
+// them, as JSON.
+// The list is filtered with the "q" query parameter, a case-insensitive
+// substring of the names of the countries or of their regions.
+func countryListHandle(w http.ResponseWriter, req *http.Request) {
+	ctx := req.Context()
+	title, err := parseMetric(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	opts, err := parseOptions(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	q := strings.ToLower(strings.TrimSpace(req.FormValue("q")))
+
+	ds, err := data.Fetch(ctx, title, 0, nil, opts.Options)
+	if err != nil {
+		logctx.From(ctx).Error("could not serve request", "title", title, "error", err)
+		http.Error(w, err.Error(), errStatus(err))
+		return
+	}
+	if notModified(w, req, ds.Date, "json") {
+		return
+	}
+
+	match := func(name string) bool {
+		return strings.Contains(strings.ToLower(name), q)
+	}
+	resp := make([]countryResponse, 0, len(ds.Table))
+	for name := range ds.Table {
+		ok := match(name)
+		for _, region := range ds.Regions[name] {
+			ok = ok || match(region)
+		}
+		if !ok {
+			continue
+		}
+		resp = append(resp, countryResponse{Name: name, Regions: ds.Regions[name]})
+	}
+	sort.Slice(resp, func(i, j int) bool { return resp[i].Name < resp[j].Name })
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(resp)
+	if err != nil {
+		logctx.From(ctx).Error("could not encode JSON response", "title", title, "error", err)
+		return
+	}
+}
+
+// countryResponse is the JSON representation of a country of a dataset.
+type countryResponse struct {
+	Name string `json:"name"`
+	// Regions are the provinces (or counties) summed into the series of
+	// the country, if any.
+	Regions []string `json:"regions,omitempty"`
+}
+
+// regionsHandle serves the sorted list of the provinces of the country
+// given with the "country" query parameter, as JSON. They are plotted with
+// the "region" query parameter.
+func regionsHandle(w http.ResponseWriter, req *http.Request) {
+	ctx := req.Context()
+	opts, err := parseOptions(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.FormValue("country") == "" {
+		http.Error(w, "missing country value", http.StatusBadRequest)
+		return
+	}
+	opts.Level = "province"
+	opts.Parent = opts.Country
+
+	names, err := provinces(ctx, opts.Options)
+	if err != nil {
+		logctx.From(ctx).Error("could not serve request", "country", opts.Parent, "error", err)
+		http.Error(w, err.Error(), errStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(names)
+	if err != nil {
+		logctx.From(ctx).Error("could not encode JSON response", "country", opts.Parent, "error", err)
+		return
+	}
+}
+
+// provinces returns the sorted names of the provinces of the country
+// opts.Parent.
+func provinces(ctx context.Context, opts data.Options) ([]string, error) {
+	ds, err := data.Fetch(ctx, "confirmed", 0, nil, opts)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(ds.Table))
+	for name := range ds.Table {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// dataResponse is the JSON representation of a dataset.
+type dataResponse struct {
+	Title     string                    `json:"title"`
+	Start     string                    `json:"start"` // date of the first column of the data
+	Date      string                    `json:"date"`  // date of the last column of the data
+	Cutoff    float64                   `json:"cutoff"`
+	Countries map[string]seriesResponse `json:"countries"`
+}
+
+type seriesResponse struct {
+	// Offset is the number of days from Start until the cutoff was reached.
+	// It is absent for countries that never reached the cutoff.
+	Offset *int        `json:"offset,omitempty"`
+	Dates  []string    `json:"dates"` // date of each value
+	Values []jsonFloat `json:"values"`
+}
+
+// parseMetric returns the dataset requested with the "metric" query
+// parameter, or with the "title" one.
+func parseMetric(req *http.Request) (string, error) {
+	if v := req.FormValue("metric"); v != "" {
+		if _, ok := cutoffs[v]; !ok {
+			return "", fmt.Errorf("invalid metric %q", v)
+		}
+		return v, nil
+	}
+	return parseTitle(req)
+}
+
+// jsonFloat is a float64 that is encoded as null when not finite.
+type jsonFloat float64
+
+func (v jsonFloat) MarshalJSON() ([]byte, error) {
+	f := float64(v)
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return []byte("null"), nil
+	}
+	return strconv.AppendFloat(nil, f, 'f', -1, 64), nil
+}
@@ -0,0 +1,75 @@
+// Copyright 2020 The covid19 Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/sbinet/covid19/internal/singleflight"
+)
+
+// imageCache holds the rendered plots, keyed by request.
+var imageCache = newRenderCache(256)
+
+// imageFlight deduplicates the concurrent renders of a plot, keyed as in
+// imageCache.
+var imageFlight singleflight.Group[[]byte]
+
+// renderCache is an in-memory cache of rendered plots.
+//
+// Each plot is stored together with the date of the data it shows, and
+// is only served for that date, so plots are rendered again once new
+// data is published.
+type renderCache struct {
+	mu   sync.Mutex
+	max  int // maximum number of stored plots
+	data map[string]renderEntry
+}
+
+type renderEntry struct {
+	raw  []byte
+	date time.Time // date of the latest data shown by the plot
+}
+
+func newRenderCache(max int) *renderCache {
+	return &renderCache{
+		max:  max,
+		data: make(map[string]renderEntry),
+	}
+}
+
+// get returns the plot stored under key, if it shows the data of date.
+func (c *renderCache) get(key string, date time.Time) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.data[key]
+	if !ok || !entry.date.Equal(date) {
+		return nil, false
+	}
+	return entry.raw, true
+}
+
+// put stores the plot raw, showing the data of date, under key.
+// When the cache is full, plots of older data are evicted first, and
+// an arbitrary plot otherwise.
+func (c *renderCache) put(key string, date time.Time, raw []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.data[key]; !ok && len(c.data) >= c.max {
+		for k, entry := range c.data {
+			if entry.date.Before(date) {
+				delete(c.data, k)
+			}
+		}
+		for k := range c.data {
+			if len(c.data) < c.max {
+				break
+			}
+			delete(c.data, k)
+		}
+	}
+	c.data[key] = renderEntry{raw: raw, date: date}
+}
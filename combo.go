@@ -0,0 +1,211 @@
+// Copyright 2020 The covid19 Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+
+	"github.com/sbinet/covid19/data"
+	"github.com/sbinet/covid19/internal/logctx"
+	"go-hep.org/x/hep/hplot"
+	"gonum.org/v1/plot"
+	"gonum.org/v1/plot/plotter"
+	"gonum.org/v1/plot/vg"
+	"gonum.org/v1/plot/vg/draw"
+)
+
+// genCombo creates a single-country chart showing the daily new cases
+// as bars (left axis) and the cumulative series as a line (right axis).
+// width is the width of the final image, used to size the bars.
+func genCombo(ctx context.Context, title string, cutoff float64, width vg.Length, opts Options) (*rightAxisPlot, error) {
+	name := opts.Country
+	ds, err := data.Fetch(ctx, title, 0, []string{name}, opts.Options)
+	if err != nil {
+		return nil, fmt.Errorf("could not fetch data: %w", err)
+	}
+	logctx.From(ctx).Info("data for", "title", title, "date", ds.Date.Format("2006-01-02"))
+
+	// the daily new values are computed before the series is trimmed at
+	// the cutoff, so that of its first day is not a spurious zero.
+	news := daily(ds.Table[name])
+	if opts.Anchor != "date" {
+		// keep the full series on the calendar axis.
+		ds.Align(cutoff)
+	}
+	cumul := ds.Table[name]
+	if len(cumul) == 0 {
+		return nil, fmt.Errorf("no data for %q", name)
+	}
+	news = news[len(news)-len(cumul):]
+
+	th := opts.theme()
+	p := hplot.New()
+	th.apply(p)
+	p.Title.Text = "CoVid-19 - " + title + " - " + name + " - " + ds.Date.Format("2006-01-02")
+	p.X.Label.Text = cutoffLabel(title, cutoff)
+	p.X.Tick.Marker = hplot.Ticks{N: 20}
+	if opts.Anchor == "date" {
+		p.X.Label.Text = "Date"
+		p.X.Tick.Marker = dateTicks(ds.Start)
+	}
+	p.Y.Label.Text = "daily new " + title + " (bars, left axis)"
+
+	// spread the bars over ~80% of the data area.
+	bw := 0.8 * 0.8 * width / vg.Length(len(news))
+	if bw <= 0 {
+		bw = 1
+	}
+	bars, err := plotter.NewBarChart(plotter.Values(news), bw)
+	if err != nil {
+		return nil, fmt.Errorf("could not create bar chart for %q: %w", name, err)
+	}
+	bars.Color = th.color(0)
+	bars.LineStyle.Width = 0
+	p.Add(bars)
+	p.Legend.Add(fmt.Sprintf("%s - daily new %8s", name, opts.format(news[len(news)-1], precCount)), bars)
+
+	// the cumulative series is rescaled to the range of the daily values,
+	// and labeled on the right axis in its own units.
+	var (
+		ymax = maxOf(cumul)
+		k    = 1.0
+	)
+	if ymax > 0 {
+		k = maxOf(news) / ymax
+		if k == 0 {
+			k = 1
+		}
+	}
+	xs := make([]float64, len(cumul))
+	ys := make([]float64, len(cumul))
+	for i, v := range cumul {
+		xs[i] = float64(i)
+		ys[i] = v * k
+	}
+	xs, ys = finite(xs, ys)
+	line, err := hplot.NewLine(hplot.ZipXY(xs, ys))
+	if err != nil {
+		return nil, fmt.Errorf("could not create line plot for %q: %w", name, err)
+	}
+	line.Color = th.Guide
+	line.Width = 2
+	p.Add(line)
+	p.Legend.Add(fmt.Sprintf("%s - cumulative %8s", name, opts.format(latest(cumul), precCount)), line)
+	p.Legend.Left = true
+	p.Legend.Top = true
+
+	p.Add(th.grid())
+
+	axis := &rightAxis{
+		Label: "cumulative " + title + " (line, right axis)",
+		Scale: k,
+		Max:   ymax,
+		Text:  p.Y.Label.TextStyle,
+		Line:  p.Y.LineStyle,
+
+		TickLabel:  p.Y.Tick.Label,
+		TickLine:   p.Y.Tick.LineStyle,
+		TickLength: p.Y.Tick.Length,
+	}
+	p.Add(axis)
+
+	return &rightAxisPlot{Plot: p, Margin: axis.margin()}, nil
+}
+
+// maxOf returns the maximum finite value of vs, or 0.
+func maxOf(vs []float64) float64 {
+	o := 0.0
+	for _, v := range vs {
+		if v > o && !math.IsInf(v, 0) {
+			o = v
+		}
+	}
+	return o
+}
+
+// rightAxisPlot draws a plot, leaving a margin on its right-hand side
+// for a secondary y-axis.
+type rightAxisPlot struct {
+	*hplot.Plot
+	Margin vg.Length
+}
+
+// Draw draws the plot to the canvas, minus the right-hand margin
+// which is only filled with the background color.
+func (p *rightAxisPlot) Draw(c draw.Canvas) {
+	if p.BackgroundColor != nil {
+		c.SetColor(p.BackgroundColor)
+		c.Fill(c.Rectangle.Path())
+	}
+	p.Plot.Draw(draw.Crop(c, 0, -p.Margin, 0, 0))
+}
+
+// rightAxis draws a secondary y-axis on the right-hand side of a plot,
+// for values that have been multiplied by Scale to fit the primary axis.
+type rightAxis struct {
+	Label string
+	Scale float64
+	Max   float64 // maximum value, in the secondary axis' units
+
+	TickLabel  draw.TextStyle
+	TickLine   draw.LineStyle
+	TickLength vg.Length
+
+	Text draw.TextStyle
+	Line draw.LineStyle
+}
+
+// margin returns the room needed on the right-hand side of the plot
+// to draw the axis.
+func (axis *rightAxis) margin() vg.Length {
+	var w vg.Length
+	for _, tick := range (plot.DefaultTicks{}).Ticks(0, axis.Max) {
+		if v := axis.TickLabel.Width(tick.Label); v > w {
+			w = v
+		}
+	}
+	return axis.TickLength + w + 2*axis.Text.Height(axis.Label)
+}
+
+// Plot implements the plot.Plotter interface.
+func (axis *rightAxis) Plot(c draw.Canvas, plt *plot.Plot) {
+	_, trY := plt.Transforms(&c)
+	x := c.Max.X
+	c.StrokeLine2(axis.Line, x, c.Min.Y, x, c.Max.Y)
+
+	var w vg.Length
+	sty := axis.TickLabel
+	sty.XAlign = draw.XLeft
+	sty.YAlign = draw.YCenter
+	for _, tick := range (plot.DefaultTicks{}).Ticks(0, axis.Max) {
+		y := trY(tick.Value * axis.Scale)
+		if !c.ContainsY(y) {
+			continue
+		}
+		n := axis.TickLength
+		if tick.IsMinor() {
+			n /= 2
+		}
+		c.StrokeLine2(axis.TickLine, x, y, x+n, y)
+		if tick.IsMinor() {
+			continue
+		}
+		c.FillText(sty, vg.Point{X: x + n, Y: y}, tick.Label)
+		if v := sty.Width(tick.Label); v > w {
+			w = v
+		}
+	}
+
+	lbl := axis.Text
+	lbl.Rotation = -math.Pi / 2
+	lbl.XAlign = draw.XCenter
+	lbl.YAlign = draw.YTop
+	c.FillText(lbl, vg.Point{
+		X: x + axis.TickLength + w + axis.Text.Height(axis.Label),
+		Y: c.Center().Y,
+	}, axis.Label)
+}
@@ -0,0 +1,128 @@
+// Copyright 2020 The covid19 Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/sbinet/covid19/data"
+)
+
+// renderCmd renders a single plot to a file and exits, without starting
+// the server.
+func renderCmd(args []string) {
+	fs := flag.NewFlagSet("render", flag.ExitOnError)
+	var (
+		metric    = fs.String("metric", "confirmed", "dataset to plot (confirmed, deaths, recovered, active, cfr)")
+		countries = fs.String("countries", "", "comma-separated list of countries to plot (default selection if empty)")
+		out       = fs.String("out", "", "output file (default covid-<metric>.<format>)")
+		format    = fs.String("format", "", "output format (png, pdf, svg), guessed from the output file name if empty")
+		query     = fs.String("query", "", "other plotting options, as the query parameters of the image endpoints (e.g. diff=1&smooth=7)")
+	)
+	setup := dataFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: covid19 render [flags]\n\nRender a single plot to a file.\n\nFlags:\n")
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+	setup()
+
+	q, err := url.ParseQuery(*query)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "covid19: invalid query %q: %+v\n", *query, err)
+		os.Exit(2)
+	}
+	if *countries != "" {
+		q.Set("countries", *countries)
+	}
+
+	err = renderFile(context.Background(), *metric, q, *format, *out)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "covid19: %+v\n", err)
+		os.Exit(1)
+	}
+}
+
+// renderFile renders the plot of the metric dataset with the plotting
+// options of q, and writes it to the file out.
+// The format, when empty, is guessed from the extension of out.
+func renderFile(ctx context.Context, metric string, q url.Values, format, out string) error {
+	cutoff, ok := cutoffs[metric]
+	if !ok {
+		return fmt.Errorf("invalid metric %q", metric)
+	}
+
+	if format == "" {
+		format = strings.TrimPrefix(filepath.Ext(out), ".")
+	}
+	if format == "" {
+		format = "png"
+	}
+	if out == "" {
+		out = "covid-" + metric + "." + format
+	}
+	q.Set("format", format)
+
+	// the options are parsed as for the image endpoints, so the plots
+	// are the same as the ones served.
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "/img-"+metric+"?"+q.Encode(), nil)
+	if err != nil {
+		return fmt.Errorf("could not create request: %w", err)
+	}
+	opts, err := parseOptions(req)
+	if err != nil {
+		return fmt.Errorf("invalid options: %w", err)
+	}
+
+	fig, err := genImage(ctx, metric, cutoff, opts)
+	if err != nil {
+		return fmt.Errorf("could not generate plot: %w", err)
+	}
+	raw, err := render(fig, opts)
+	if err != nil {
+		return err
+	}
+
+	err = os.WriteFile(out, raw, 0644)
+	if err != nil {
+		return fmt.Errorf("could not write image file: %w", err)
+	}
+	return nil
+}
+
+// fetchCmd downloads the data files of the data source to the data
+// directory and exits, so the plots can later be served or rendered
+// from that snapshot of the data without network access.
+func fetchCmd(args []string) {
+	fs := flag.NewFlagSet("fetch", flag.ExitOnError)
+	setup := dataFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: covid19 fetch -data-dir DIR [flags]\n\nDownload the data files to the DIR directory.\n\nFlags:\n")
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+	setup()
+
+	if data.Dir == "" {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	fnames, err := data.Store(context.Background(), data.Backend, data.Dir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "covid19: %+v\n", err)
+		os.Exit(1)
+	}
+	for _, fname := range fnames {
+		fmt.Println(fname)
+	}
+}
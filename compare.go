@@ -0,0 +1,114 @@
+// Copyright 2020 The covid19 Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/sbinet/covid19/internal/logctx"
+	"go-hep.org/x/hep/hplot"
+	"gonum.org/v1/plot/vg"
+	"gonum.org/v1/plot/vg/draw"
+)
+
+// compareHandle serves small multiples of the same dataset shown
+// with a {log, linear} y-axis scale (rows) and with two different
+// cutoffs (columns), to illustrate how presentation choices change
+// the story told by a chart.
+func compareHandle(w http.ResponseWriter, req *http.Request) {
+	ctx := req.Context()
+	title, err := parseTitle(req)
+	if err != nil {
+		imageError(w, err.Error())
+		return
+	}
+
+	cuts := []float64{cutoffs[title], 10 * cutoffs[title]}
+	if v := req.FormValue("cutoffs"); v != "" {
+		toks := strings.Split(v, ",")
+		if len(toks) != len(cuts) {
+			imageError(w, fmt.Sprintf("invalid cutoffs value %q: need 2 cutoffs", v))
+			return
+		}
+		for i, tok := range toks {
+			cuts[i], err = strconv.ParseFloat(strings.TrimSpace(tok), 64)
+			if err != nil || cuts[i] <= 0 {
+				imageError(w, fmt.Sprintf("invalid cutoff value %q", tok))
+				return
+			}
+		}
+	}
+
+	opts, err := parseOptions(req)
+	if err != nil {
+		optionsError(w, err)
+		return
+	}
+
+	if date, err := dataDate(ctx, title, opts); err == nil && notModified(w, req, date, opts.Format) {
+		return
+	}
+
+	fig, err := genCompare(ctx, title, cuts, opts)
+	if err != nil {
+		logctx.From(ctx).Error("could not serve request", "error", err)
+		imageError(w, err.Error())
+		return
+	}
+
+	err = encodeImage(w, fig, opts)
+	if err != nil {
+		logctx.From(ctx).Error("could not serve request", "error", err)
+		imageError(w, err.Error())
+		return
+	}
+}
+
+func genCompare(ctx context.Context, title string, cuts []float64, opts Options) (figure, error) {
+	scales := []string{"log", "linear"}
+	tp := hplot.NewTiledPlot(draw.Tiles{
+		Rows: len(scales),
+		Cols: len(cuts),
+		PadX: 1 * vg.Centimeter,
+		PadY: 1 * vg.Centimeter,
+	})
+
+	for i, scale := range scales {
+		for j, cutoff := range cuts {
+			o := opts
+			o.Scale = scale
+			p, err := genPlot(ctx, title, cutoff, o)
+			if err != nil {
+				return figure{}, err
+			}
+			p.Title.Text = compareTitle(title, scale, cutoff)
+			tp.Plots[i*tp.Tiles.Cols+j] = p
+		}
+	}
+
+	// fill the padding between the tiles too.
+	th := opts.theme()
+	tiles := func(c draw.Canvas) {
+		th.fill(c)
+		tp.Draw(c)
+	}
+
+	const sz = 20 * vg.Centimeter
+	return figure{drawer: drawFunc(tiles), width: 2 * sz * math.Phi, height: 2 * sz}, nil
+}
+
+// compareTitle returns the title of the panel of the title dataset drawn
+// with the y-axis scale and cutoff.
+func compareTitle(title, scale string, cutoff float64) string {
+	return fmt.Sprintf(
+		"CoVid-19 - %s - %s scale, cutoff=%s",
+		title, scale, strconv.FormatFloat(cutoff, 'f', -1, 64),
+	)
+}
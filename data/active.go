@@ -0,0 +1,84 @@
+// Copyright 2020 The covid19 Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package data
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/sbinet/covid19/internal/logctx"
+	"github.com/sbinet/covid19/internal/parallel"
+	"gonum.org/v1/gonum/floats"
+)
+
+// fetchActive retrieves the number of active cases of the requested
+// countries, computed as confirmed - deaths - recovered, and trims each
+// series to the first day its value reached cutoff.
+//
+// The recovered data is not reported by all countries: missing countries
+// are considered as having no recovered cases.
+func fetchActive(ctx context.Context, cutoff float64, countries []string, opts Options) (Dataset, error) {
+	if opts.Level == "state" || opts.Level == "county" {
+		return Dataset{}, fmt.Errorf("active cases are not available at the %s level", opts.Level)
+	}
+
+	// retrieve the untrimmed series, so they share the same days.
+	var confirmed, deaths, recovered Dataset
+	err := parallel.Do(
+		func() (err error) {
+			confirmed, err = Fetch(ctx, "confirmed", 0, countries, opts)
+			if err != nil {
+				return fmt.Errorf("could not fetch confirmed cases: %w", err)
+			}
+			return nil
+		},
+		func() (err error) {
+			deaths, err = Fetch(ctx, "deaths", 0, countries, opts)
+			if err != nil {
+				return fmt.Errorf("could not fetch deaths: %w", err)
+			}
+			return nil
+		},
+		func() (err error) {
+			recovered, err = Fetch(ctx, "recovered", 0, nil, opts)
+			if err != nil {
+				return fmt.Errorf("could not fetch recovered cases: %w", err)
+			}
+			return nil
+		},
+	)
+	if err != nil {
+		return Dataset{}, err
+	}
+
+	ds := Dataset{
+		Date:    confirmed.Date,
+		Start:   confirmed.Start,
+		Table:   make(map[string][]float64, len(confirmed.Table)),
+		Cutoff:  make(map[string]int, len(confirmed.Table)),
+		Regions: confirmed.Regions,
+	}
+	for name, ys := range confirmed.Table {
+		active := make([]float64, len(ys))
+		copy(active, ys)
+		if vs, ok := deaths.Table[name]; ok && len(vs) == len(ys) {
+			floats.Sub(active, vs)
+		}
+		vs, ok := recovered.Table[name]
+		if !ok && isAggregate(name) {
+			vs, _ = aggregate(recovered.Table, name)
+			ok = vs != nil
+		}
+		if ok && len(vs) == len(ys) {
+			floats.Sub(active, vs)
+		} else {
+			logctx.From(ctx).Warn("no recovered data, assuming none", "country", name)
+		}
+		ds.Table[name] = active
+	}
+	ds.Align(cutoff)
+
+	return ds, nil
+}
@@ -0,0 +1,178 @@
+// Copyright 2020 The covid19 Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package data
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"gonum.org/v1/gonum/floats"
+)
+
+// World is the aggregate of all the countries of the data.
+const World = "World"
+
+// Aggregates returns the sorted names of the aggregates of countries,
+// such as World, EU27 or the continents, that may be requested in place
+// of a country.
+func Aggregates() []string {
+	names := []string{World}
+	for name := range aggregates {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// isAggregate returns whether name is the name of an aggregate.
+func isAggregate(name string) bool {
+	_, ok := aggregates[name]
+	return ok || name == World
+}
+
+// fetchAggregates retrieves the untrimmed title dataset of the requested
+// countries, some of which are aggregates: the series of an aggregate is
+// the sum of the series of its members present in the data, which are
+// listed as its regions.
+func fetchAggregates(ctx context.Context, title string, countries []string, opts Options) (Dataset, error) {
+	all, err := Backend.Fetch(ctx, title, nil, opts)
+	if err != nil {
+		return Dataset{}, err
+	}
+	cleanup(ctx, title, &all)
+
+	ds := Dataset{
+		Date:    all.Date,
+		Start:   all.Start,
+		Table:   make(map[string][]float64, len(countries)),
+		Cutoff:  make(map[string]int, len(countries)),
+		Regions: make(map[string][]string, len(countries)),
+	}
+	var unknown []string
+	for _, name := range countries {
+		if ys, ok := all.Table[name]; ok {
+			ds.Table[name] = ys
+			if vs, ok := all.Regions[name]; ok {
+				ds.Regions[name] = vs
+			}
+			continue
+		}
+		if !isAggregate(name) {
+			unknown = append(unknown, name)
+			continue
+		}
+
+		sum, members := aggregate(all.Table, name)
+		if sum == nil {
+			return Dataset{}, fmt.Errorf("no data for the countries of %s", name)
+		}
+		ds.Table[name] = sum
+		ds.Regions[name] = members
+	}
+	if len(unknown) > 0 {
+		known := make([]string, 0, len(all.Table))
+		for name := range all.Table {
+			known = append(known, name)
+		}
+		return Dataset{}, unknownCountries(unknown, append(known, Aggregates()...))
+	}
+	return ds, nil
+}
+
+// aggregate returns the sum of the series of table of the members of the
+// aggregate name, and the sorted names of the members present in table.
+// The sum is nil when none are present.
+func aggregate(table map[string][]float64, name string) ([]float64, []string) {
+	members := aggregates[name]
+	if name == World {
+		members = nil
+		for v := range table {
+			members = append(members, v)
+		}
+	}
+
+	var (
+		sum     []float64
+		present []string
+	)
+	for _, v := range members {
+		ys, ok := table[v]
+		if !ok {
+			continue
+		}
+		if sum == nil {
+			sum = make([]float64, len(ys))
+		}
+		floats.Add(sum, ys)
+		present = append(present, v)
+	}
+	sort.Strings(present)
+	return sum, present
+}
+
+// aggregates holds the members of the aggregates of countries, by the
+// names used by the CSSE data.
+// Transcontinental countries are counted with the continent they are
+// usually associated with: Russia and Cyprus with Europe, Turkey and the
+// countries of the Caucasus with Asia.
+var aggregates = map[string][]string{
+	"EU27": {
+		"Austria", "Belgium", "Bulgaria", "Croatia", "Cyprus", "Czechia",
+		"Denmark", "Estonia", "Finland", "France", "Germany", "Greece",
+		"Hungary", "Ireland", "Italy", "Latvia", "Lithuania", "Luxembourg",
+		"Malta", "Netherlands", "Poland", "Portugal", "Romania", "Slovakia",
+		"Slovenia", "Spain", "Sweden",
+	},
+	"Africa": {
+		"Algeria", "Angola", "Benin", "Botswana", "Burkina Faso", "Burundi",
+		"Cabo Verde", "Cameroon", "Central African Republic", "Chad",
+		"Comoros", "Congo (Brazzaville)", "Congo (Kinshasa)",
+		"Cote d'Ivoire", "Djibouti", "Egypt", "Equatorial Guinea", "Eritrea",
+		"Eswatini", "Ethiopia", "Gabon", "Gambia", "Ghana", "Guinea",
+		"Guinea-Bissau", "Kenya", "Lesotho", "Liberia", "Libya",
+		"Madagascar", "Malawi", "Mali", "Mauritania", "Mauritius", "Morocco",
+		"Mozambique", "Namibia", "Niger", "Nigeria", "Rwanda",
+		"Sao Tome and Principe", "Senegal", "Seychelles", "Sierra Leone",
+		"Somalia", "South Africa", "South Sudan", "Sudan", "Tanzania", "Togo",
+		"Tunisia", "Uganda", "Western Sahara", "Zambia", "Zimbabwe",
+	},
+	"Americas": {
+		"Antigua and Barbuda", "Argentina", "Bahamas", "Barbados", "Belize",
+		"Bolivia", "Brazil", "Canada", "Chile", "Colombia", "Costa Rica",
+		"Cuba", "Dominica", "Dominican Republic", "Ecuador", "El Salvador",
+		"Grenada", "Guatemala", "Guyana", "Haiti", "Honduras", "Jamaica",
+		"Mexico", "Nicaragua", "Panama", "Paraguay", "Peru",
+		"Saint Kitts and Nevis", "Saint Lucia",
+		"Saint Vincent and the Grenadines", "Suriname", "Trinidad and Tobago",
+		"US", "Uruguay", "Venezuela",
+	},
+	"Asia": {
+		"Afghanistan", "Armenia", "Azerbaijan", "Bahrain", "Bangladesh",
+		"Bhutan", "Brunei", "Burma", "Cambodia", "China", "Georgia", "India",
+		"Indonesia", "Iran", "Iraq", "Israel", "Japan", "Jordan",
+		"Kazakhstan", "Korea, South", "Kuwait", "Kyrgyzstan", "Laos",
+		"Lebanon", "Malaysia", "Maldives", "Mongolia", "Nepal", "Oman",
+		"Pakistan", "Philippines", "Qatar", "Saudi Arabia", "Singapore",
+		"Sri Lanka", "Syria", "Taiwan*", "Tajikistan", "Thailand",
+		"Timor-Leste", "Turkey", "United Arab Emirates", "Uzbekistan",
+		"Vietnam", "West Bank and Gaza", "Yemen",
+	},
+	"Europe": {
+		"Albania", "Andorra", "Austria", "Belarus", "Belgium",
+		"Bosnia and Herzegovina", "Bulgaria", "Croatia", "Cyprus", "Czechia",
+		"Denmark", "Estonia", "Finland", "France", "Germany", "Greece",
+		"Holy See", "Hungary", "Iceland", "Ireland", "Italy", "Kosovo",
+		"Latvia", "Liechtenstein", "Lithuania", "Luxembourg", "Malta",
+		"Moldova", "Monaco", "Montenegro", "Netherlands", "North Macedonia",
+		"Norway", "Poland", "Portugal", "Romania", "Russia", "San Marino",
+		"Serbia", "Slovakia", "Slovenia", "Spain", "Sweden", "Switzerland",
+		"Ukraine", "United Kingdom",
+	},
+	"Oceania": {
+		"Australia", "Fiji", "Marshall Islands", "New Zealand",
+		"Papua New Guinea", "Samoa", "Solomon Islands", "Vanuatu",
+	},
+}
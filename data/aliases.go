@@ -0,0 +1,349 @@
+// Copyright 2020 The covid19 Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package data
+
+import (
+	"sort"
+	"strings"
+)
+
+// CanonicalName returns the name used by the CSSE data for the country
+// name, which may be a common variant of that name, or its ISO 3166
+// alpha-2 or alpha-3 code.
+func CanonicalName(name string) string {
+	name = strings.TrimSpace(name)
+	if v, ok := countryAliases[strings.ToLower(name)]; ok {
+		return v
+	}
+	if v, ok := isoNames[strings.ToUpper(name)]; ok {
+		return v
+	}
+	return name
+}
+
+// countryAliases maps common variants of country names, in lower case,
+// to the names used by the CSSE data.
+var countryAliases = map[string]string{
+	"uk":                         "United Kingdom",
+	"great britain":              "United Kingdom",
+	"britain":                    "United Kingdom",
+	"usa":                        "US",
+	"united states":              "US",
+	"united states of america":   "US",
+	"south korea":                "Korea, South",
+	"korea":                      "Korea, South",
+	"republic of korea":          "Korea, South",
+	"czech republic":             "Czechia",
+	"taiwan":                     "Taiwan*",
+	"ivory coast":                "Cote d'Ivoire",
+	"myanmar":                    "Burma",
+	"cape verde":                 "Cabo Verde",
+	"swaziland":                  "Eswatini",
+	"macedonia":                  "North Macedonia",
+	"vatican":                    "Holy See",
+	"russian federation":         "Russia",
+	"iran (islamic republic of)": "Iran",
+	"drc":                        "Congo (Kinshasa)",
+	"palestine":                  "West Bank and Gaza",
+	"holland":                    "Netherlands",
+	"the netherlands":            "Netherlands",
+	"viet nam":                   "Vietnam",
+	"lao pdr":                    "Laos",
+	"syrian arab republic":       "Syria",
+	"turkiye":                    "Turkey",
+	"east timor":                 "Timor-Leste",
+	"dr congo":                   "Congo (Kinshasa)",
+	"uae":                        "United Arab Emirates",
+
+	// aggregates of countries.
+	"world":          World,
+	"eu":             "EU27",
+	"eu27":           "EU27",
+	"european union": "EU27",
+	"africa":         "Africa",
+	"americas":       "Americas",
+	"asia":           "Asia",
+	"europe":         "Europe",
+	"oceania":        "Oceania",
+}
+
+// isoNames maps the ISO 3166 alpha-2 and alpha-3 codes of the countries
+// to the names used by the CSSE data.
+var isoNames = func() map[string]string {
+	names := make(map[string]string, 2*len(isoCodes))
+	for _, c := range isoCodes {
+		names[c.alpha2] = c.name
+		names[c.alpha3] = c.name
+	}
+	return names
+}()
+
+// isoCodes lists the ISO 3166 codes of the countries of the CSSE data.
+// Kosovo, which has no ISO code, uses the codes of the European Commission.
+var isoCodes = []struct {
+	alpha2, alpha3 string
+	name           string
+}{
+	{"AF", "AFG", "Afghanistan"},
+	{"AL", "ALB", "Albania"},
+	{"DZ", "DZA", "Algeria"},
+	{"AD", "AND", "Andorra"},
+	{"AO", "AGO", "Angola"},
+	{"AG", "ATG", "Antigua and Barbuda"},
+	{"AR", "ARG", "Argentina"},
+	{"AM", "ARM", "Armenia"},
+	{"AU", "AUS", "Australia"},
+	{"AT", "AUT", "Austria"},
+	{"AZ", "AZE", "Azerbaijan"},
+	{"BS", "BHS", "Bahamas"},
+	{"BH", "BHR", "Bahrain"},
+	{"BD", "BGD", "Bangladesh"},
+	{"BB", "BRB", "Barbados"},
+	{"BY", "BLR", "Belarus"},
+	{"BE", "BEL", "Belgium"},
+	{"BZ", "BLZ", "Belize"},
+	{"BJ", "BEN", "Benin"},
+	{"BT", "BTN", "Bhutan"},
+	{"BO", "BOL", "Bolivia"},
+	{"BA", "BIH", "Bosnia and Herzegovina"},
+	{"BW", "BWA", "Botswana"},
+	{"BR", "BRA", "Brazil"},
+	{"BN", "BRN", "Brunei"},
+	{"BG", "BGR", "Bulgaria"},
+	{"BF", "BFA", "Burkina Faso"},
+	{"MM", "MMR", "Burma"},
+	{"BI", "BDI", "Burundi"},
+	{"CV", "CPV", "Cabo Verde"},
+	{"KH", "KHM", "Cambodia"},
+	{"CM", "CMR", "Cameroon"},
+	{"CA", "CAN", "Canada"},
+	{"CF", "CAF", "Central African Republic"},
+	{"TD", "TCD", "Chad"},
+	{"CL", "CHL", "Chile"},
+	{"CN", "CHN", "China"},
+	{"CO", "COL", "Colombia"},
+	{"KM", "COM", "Comoros"},
+	{"CG", "COG", "Congo (Brazzaville)"},
+	{"CD", "COD", "Congo (Kinshasa)"},
+	{"CR", "CRI", "Costa Rica"},
+	{"CI", "CIV", "Cote d'Ivoire"},
+	{"HR", "HRV", "Croatia"},
+	{"CU", "CUB", "Cuba"},
+	{"CY", "CYP", "Cyprus"},
+	{"CZ", "CZE", "Czechia"},
+	{"DK", "DNK", "Denmark"},
+	{"DJ", "DJI", "Djibouti"},
+	{"DM", "DMA", "Dominica"},
+	{"DO", "DOM", "Dominican Republic"},
+	{"EC", "ECU", "Ecuador"},
+	{"EG", "EGY", "Egypt"},
+	{"SV", "SLV", "El Salvador"},
+	{"GQ", "GNQ", "Equatorial Guinea"},
+	{"ER", "ERI", "Eritrea"},
+	{"EE", "EST", "Estonia"},
+	{"SZ", "SWZ", "Eswatini"},
+	{"ET", "ETH", "Ethiopia"},
+	{"FJ", "FJI", "Fiji"},
+	{"FI", "FIN", "Finland"},
+	{"FR", "FRA", "France"},
+	{"GA", "GAB", "Gabon"},
+	{"GM", "GMB", "Gambia"},
+	{"GE", "GEO", "Georgia"},
+	{"DE", "DEU", "Germany"},
+	{"GH", "GHA", "Ghana"},
+	{"GR", "GRC", "Greece"},
+	{"GD", "GRD", "Grenada"},
+	{"GT", "GTM", "Guatemala"},
+	{"GN", "GIN", "Guinea"},
+	{"GW", "GNB", "Guinea-Bissau"},
+	{"GY", "GUY", "Guyana"},
+	{"HT", "HTI", "Haiti"},
+	{"VA", "VAT", "Holy See"},
+	{"HN", "HND", "Honduras"},
+	{"HU", "HUN", "Hungary"},
+	{"IS", "ISL", "Iceland"},
+	{"IN", "IND", "India"},
+	{"ID", "IDN", "Indonesia"},
+	{"IR", "IRN", "Iran"},
+	{"IQ", "IRQ", "Iraq"},
+	{"IE", "IRL", "Ireland"},
+	{"IL", "ISR", "Israel"},
+	{"IT", "ITA", "Italy"},
+	{"JM", "JAM", "Jamaica"},
+	{"JP", "JPN", "Japan"},
+	{"JO", "JOR", "Jordan"},
+	{"KZ", "KAZ", "Kazakhstan"},
+	{"KE", "KEN", "Kenya"},
+	{"KR", "KOR", "Korea, South"},
+	{"XK", "XKX", "Kosovo"},
+	{"KW", "KWT", "Kuwait"},
+	{"KG", "KGZ", "Kyrgyzstan"},
+	{"LA", "LAO", "Laos"},
+	{"LV", "LVA", "Latvia"},
+	{"LB", "LBN", "Lebanon"},
+	{"LS", "LSO", "Lesotho"},
+	{"LR", "LBR", "Liberia"},
+	{"LY", "LBY", "Libya"},
+	{"LI", "LIE", "Liechtenstein"},
+	{"LT", "LTU", "Lithuania"},
+	{"LU", "LUX", "Luxembourg"},
+	{"MG", "MDG", "Madagascar"},
+	{"MW", "MWI", "Malawi"},
+	{"MY", "MYS", "Malaysia"},
+	{"MV", "MDV", "Maldives"},
+	{"ML", "MLI", "Mali"},
+	{"MT", "MLT", "Malta"},
+	{"MH", "MHL", "Marshall Islands"},
+	{"MR", "MRT", "Mauritania"},
+	{"MU", "MUS", "Mauritius"},
+	{"MX", "MEX", "Mexico"},
+	{"MD", "MDA", "Moldova"},
+	{"MC", "MCO", "Monaco"},
+	{"MN", "MNG", "Mongolia"},
+	{"ME", "MNE", "Montenegro"},
+	{"MA", "MAR", "Morocco"},
+	{"MZ", "MOZ", "Mozambique"},
+	{"NA", "NAM", "Namibia"},
+	{"NP", "NPL", "Nepal"},
+	{"NL", "NLD", "Netherlands"},
+	{"NZ", "NZL", "New Zealand"},
+	{"NI", "NIC", "Nicaragua"},
+	{"NE", "NER", "Niger"},
+	{"NG", "NGA", "Nigeria"},
+	{"MK", "MKD", "North Macedonia"},
+	{"NO", "NOR", "Norway"},
+	{"OM", "OMN", "Oman"},
+	{"PK", "PAK", "Pakistan"},
+	{"PA", "PAN", "Panama"},
+	{"PG", "PNG", "Papua New Guinea"},
+	{"PY", "PRY", "Paraguay"},
+	{"PE", "PER", "Peru"},
+	{"PH", "PHL", "Philippines"},
+	{"PL", "POL", "Poland"},
+	{"PT", "PRT", "Portugal"},
+	{"QA", "QAT", "Qatar"},
+	{"RO", "ROU", "Romania"},
+	{"RU", "RUS", "Russia"},
+	{"RW", "RWA", "Rwanda"},
+	{"KN", "KNA", "Saint Kitts and Nevis"},
+	{"LC", "LCA", "Saint Lucia"},
+	{"VC", "VCT", "Saint Vincent and the Grenadines"},
+	{"WS", "WSM", "Samoa"},
+	{"SM", "SMR", "San Marino"},
+	{"ST", "STP", "Sao Tome and Principe"},
+	{"SA", "SAU", "Saudi Arabia"},
+	{"SN", "SEN", "Senegal"},
+	{"RS", "SRB", "Serbia"},
+	{"SC", "SYC", "Seychelles"},
+	{"SL", "SLE", "Sierra Leone"},
+	{"SG", "SGP", "Singapore"},
+	{"SK", "SVK", "Slovakia"},
+	{"SI", "SVN", "Slovenia"},
+	{"SB", "SLB", "Solomon Islands"},
+	{"SO", "SOM", "Somalia"},
+	{"ZA", "ZAF", "South Africa"},
+	{"SS", "SSD", "South Sudan"},
+	{"ES", "ESP", "Spain"},
+	{"LK", "LKA", "Sri Lanka"},
+	{"SD", "SDN", "Sudan"},
+	{"SR", "SUR", "Suriname"},
+	{"SE", "SWE", "Sweden"},
+	{"CH", "CHE", "Switzerland"},
+	{"SY", "SYR", "Syria"},
+	{"TW", "TWN", "Taiwan*"},
+	{"TJ", "TJK", "Tajikistan"},
+	{"TZ", "TZA", "Tanzania"},
+	{"TH", "THA", "Thailand"},
+	{"TL", "TLS", "Timor-Leste"},
+	{"TG", "TGO", "Togo"},
+	{"TT", "TTO", "Trinidad and Tobago"},
+	{"TN", "TUN", "Tunisia"},
+	{"TR", "TUR", "Turkey"},
+	{"US", "USA", "US"},
+	{"UG", "UGA", "Uganda"},
+	{"UA", "UKR", "Ukraine"},
+	{"AE", "ARE", "United Arab Emirates"},
+	{"GB", "GBR", "United Kingdom"},
+	{"UY", "URY", "Uruguay"},
+	{"UZ", "UZB", "Uzbekistan"},
+	{"VU", "VUT", "Vanuatu"},
+	{"VE", "VEN", "Venezuela"},
+	{"VN", "VNM", "Vietnam"},
+	{"PS", "PSE", "West Bank and Gaza"},
+	{"EH", "ESH", "Western Sahara"},
+	{"YE", "YEM", "Yemen"},
+	{"ZM", "ZMB", "Zambia"},
+	{"ZW", "ZWE", "Zimbabwe"},
+}
+
+// closeMatches returns the names of known closest to name, by edit
+// distance ignoring case, for suggestions of the intended name of an
+// unknown country.
+// Names longer than maxMatchLen are not matched, as the cost of the edit
+// distance grows with their length.
+func closeMatches(name string, known []string) []string {
+	const max = 3 // maximum number of matches
+	if len(name) > maxMatchLen {
+		return nil
+	}
+
+	type match struct {
+		name string
+		dist int
+	}
+	var (
+		lower = strings.ToLower(name)
+		ms    []match
+	)
+	for _, v := range known {
+		lv := strings.ToLower(v)
+		d := editDistance(lower, lv)
+		// allow about one typo every 3 letters, or a name starting
+		// with the requested one, such as "Korea" for "Korea, South".
+		if d > 1+len(lower)/3 && !(len(lower) >= 3 && strings.HasPrefix(lv, lower)) {
+			continue
+		}
+		ms = append(ms, match{v, d})
+	}
+	sort.Slice(ms, func(i, j int) bool {
+		if ms[i].dist != ms[j].dist {
+			return ms[i].dist < ms[j].dist
+		}
+		return ms[i].name < ms[j].name
+	})
+
+	var names []string
+	for i := 0; i < len(ms) && i < max; i++ {
+		names = append(names, ms[i].name)
+	}
+	return names
+}
+
+// maxMatchLen is the length, in bytes, of the longest name closeMatches
+// looks for matches of. Country names are much shorter.
+const maxMatchLen = 64
+
+// editDistance returns the Levenshtein distance between a and b.
+// Its cost is proportional to the product of their lengths.
+func editDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := range ra {
+		cur[0] = i + 1
+		for j := range rb {
+			cost := 1
+			if ra[i] == rb[j] {
+				cost = 0
+			}
+			cur[j+1] = min(prev[j+1]+1, cur[j]+1, prev[j]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(rb)]
+}
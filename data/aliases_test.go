@@ -0,0 +1,61 @@
+// Copyright 2020 The covid19 Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package data
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCanonicalName(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		want string
+	}{
+		{"South Korea", "Korea, South"},
+		{"south korea", "Korea, South"},
+		{"  South Korea ", "Korea, South"},
+		{"Korea, South", "Korea, South"},
+		{"UK", "United Kingdom"},
+		{"USA", "US"},
+		{"Czech Republic", "Czechia"},
+		{"Taiwan", "Taiwan*"},
+		{"FR", "France"},
+		{"ita", "Italy"},
+		{"KOR", "Korea, South"},
+		{"eu", "EU27"},
+		{"world", World},
+		// unknown names are kept as they are.
+		{"France", "France"},
+		{"Atlantis", "Atlantis"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := CanonicalName(tc.name); got != tc.want {
+				t.Fatalf("invalid name: got=%q, want=%q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestUnknownCountries(t *testing.T) {
+	long := strings.Repeat("a", 100)
+	known := []string{"France", "Germany", "Italy", "Korea, South", long}
+	names := []string{"Frnace", "Itlay", "Korea", "Atlantis", long[1:], "Germny", "Frence"}
+
+	err := unknownCountries(names, known)
+	if !reflect.DeepEqual(err.Names, names) {
+		t.Fatalf("invalid names:\ngot= %q\nwant=%q", err.Names, names)
+	}
+	// only the first names get suggestions, except the overlong ones.
+	want := map[string][]string{
+		"Frnace": {"France"},
+		"Itlay":  {"Italy"},
+		"Korea":  {"Korea, South"},
+	}
+	if !reflect.DeepEqual(err.Matches, want) {
+		t.Fatalf("invalid matches:\ngot= %q\nwant=%q", err.Matches, want)
+	}
+}
@@ -0,0 +1,150 @@
+// Copyright 2020 The covid19 Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package data
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sbinet/covid19/internal/logctx"
+)
+
+// archive writes the raw data file stored under key to the ArchiveDir
+// directory, named after the date of its latest data, as returned by latest.
+// Files already archived are left untouched.
+func archive(ctx context.Context, key string, raw []byte, latest func(raw []byte) (time.Time, error)) error {
+	date, err := latest(raw)
+	if err != nil {
+		return err
+	}
+
+	fname := filepath.Join(ArchiveDir, key+"-"+date.Format("2006-01-02")+".csv")
+	if _, err := os.Stat(fname); err == nil {
+		return nil
+	}
+
+	// write to a temporary file first, so a partial file is never archived.
+	tmp := fname + ".tmp"
+	err = os.WriteFile(tmp, raw, 0644)
+	if err != nil {
+		return fmt.Errorf("could not write archive file: %w", err)
+	}
+	err = os.Rename(tmp, fname)
+	if err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("could not rename archive file: %w", err)
+	}
+	logctx.From(ctx).Info("data archived", "key", key, "file", fname)
+	return nil
+}
+
+// csvDate returns the date of the latest data of a CSSE time series file,
+// the last column of its header.
+func csvDate(raw []byte) (time.Time, error) {
+	hdr, err := csv.NewReader(bytes.NewReader(raw)).Read()
+	if err != nil {
+		return time.Time{}, fmt.Errorf("could not read CSV header: %w", err)
+	}
+	date, err := time.Parse("1/2/06", hdr[len(hdr)-1])
+	if err != nil {
+		return time.Time{}, fmt.Errorf("could not parse CSV header date: %w", err)
+	}
+	return date, nil
+}
+
+// ErrNotArchived is returned when no version of a data file was archived
+// as of the requested day.
+var ErrNotArchived = errors.New("no archived data")
+
+// archived returns the version of the data file stored under key, as it
+// was on date: the archived file with the latest data until that day.
+func archived(key string, date time.Time) ([]byte, error) {
+	if ArchiveDir == "" {
+		return nil, fmt.Errorf("could not retrieve data as of %s: %w", date.Format("2006-01-02"), ErrNotArchived)
+	}
+	fnames, err := filepath.Glob(filepath.Join(ArchiveDir, key+"-????-??-??.csv"))
+	if err != nil {
+		return nil, fmt.Errorf("could not list archive files: %w", err)
+	}
+	// dates sort lexically, so the latest file comes last.
+	sort.Strings(fnames)
+
+	want := key + "-" + date.Format("2006-01-02") + ".csv"
+	fname := ""
+	for _, v := range fnames {
+		if filepath.Base(v) > want {
+			break
+		}
+		fname = v
+	}
+	if fname == "" {
+		return nil, fmt.Errorf("could not retrieve %s data as of %s: %w", key, date.Format("2006-01-02"), ErrNotArchived)
+	}
+
+	raw, err := os.ReadFile(fname)
+	if err != nil {
+		return nil, fmt.Errorf("could not read archive file: %w", err)
+	}
+	return raw, nil
+}
+
+// RestoreArchive fills the data cache with the latest archived version of
+// each data file, the one named after the latest date, when it was
+// archived today, so a restarted server does not download the data again.
+// The restored data is refreshed as usual once the cache entry expires.
+func RestoreArchive(ctx context.Context) error {
+	fnames, err := filepath.Glob(filepath.Join(ArchiveDir, "*-????-??-??.csv"))
+	if err != nil {
+		return fmt.Errorf("could not list archive files: %w", err)
+	}
+	// the files are named after the date of their latest data, so the
+	// latest version of each key is the one with the latest date.
+	type file struct {
+		name string
+		date time.Time
+	}
+	latest := make(map[string]file)
+	for _, fname := range fnames {
+		base := strings.TrimSuffix(filepath.Base(fname), ".csv")
+		i := len(base) - len("2006-01-02")
+		date, err := time.Parse("2006-01-02", base[i:])
+		if err != nil {
+			// not an archived version.
+			continue
+		}
+		key := base[:i-1]
+		if f, ok := latest[key]; ok && !date.After(f.date) {
+			continue
+		}
+		latest[key] = file{name: fname, date: date}
+	}
+
+	today := time.Now().Format("2006-01-02")
+	for key, f := range latest {
+		fi, err := os.Stat(f.name)
+		if err != nil {
+			return fmt.Errorf("could not stat archive file: %w", err)
+		}
+		// the modification time of the file is the time it was downloaded.
+		if fi.ModTime().Format("2006-01-02") != today {
+			continue
+		}
+		raw, err := os.ReadFile(f.name)
+		if err != nil {
+			return fmt.Errorf("could not read archive file: %w", err)
+		}
+		dataCache.put(key, version{raw: raw, date: f.date}, fi.ModTime())
+		logctx.From(ctx).Info("archived data restored", "key", key, "file", f.name)
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+// Copyright 2020 The covid19 Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package data
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRestoreArchive(t *testing.T) {
+	dir := t.TempDir()
+	origDir, origCache := ArchiveDir, dataCache
+	ArchiveDir, dataCache = dir, newCache()
+	t.Cleanup(func() { ArchiveDir, dataCache = origDir, origCache })
+
+	now := time.Now()
+	for _, f := range []struct {
+		name  string
+		mtime time.Time
+	}{
+		{"confirmed_global-2020-03-19.csv", now.Add(-2 * time.Second)},
+		{"confirmed_global-2020-03-20.csv", now.Add(-3 * time.Second)},
+		// downloaded last, but with older data.
+		{"confirmed_global-2020-03-09.csv", now.Add(-1 * time.Second)},
+		// not an archived version.
+		{"confirmed_global-2020-99-99.csv", now},
+	} {
+		fname := filepath.Join(dir, f.name)
+		err := os.WriteFile(fname, []byte(f.name), 0644)
+		if err != nil {
+			t.Fatalf("could not write archive file: %+v", err)
+		}
+		err = os.Chtimes(fname, f.mtime, f.mtime)
+		if err != nil {
+			t.Fatalf("could not set modification time: %+v", err)
+		}
+	}
+
+	err := RestoreArchive(context.Background())
+	if err != nil {
+		t.Fatalf("could not restore archive: %+v", err)
+	}
+
+	v, ok := dataCache.lookup("confirmed_global")
+	if !ok {
+		t.Fatalf("archived data not restored")
+	}
+	if got, want := string(v.raw), "confirmed_global-2020-03-20.csv"; got != want {
+		t.Fatalf("invalid restored version: got=%q, want=%q", got, want)
+	}
+	if want := time.Date(2020, 3, 20, 0, 0, 0, 0, time.UTC); !v.date.Equal(want) {
+		t.Fatalf("invalid restored date: got=%v, want=%v", v.date, want)
+	}
+}
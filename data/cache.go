@@ -0,0 +1,138 @@
+// Copyright 2020 The covid19 Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package data
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/sbinet/covid19/internal/singleflight"
+)
+
+// CacheTTL is the time after which the cached data files are refreshed.
+var CacheTTL = 1 * time.Hour
+
+// dataCache holds the raw CSV data files, keyed by title.
+var dataCache = newCache()
+
+// cache is an in-memory cache of raw data, with a time-to-live of CacheTTL.
+//
+// Expired entries are still served while a background refresh
+// retrieves a new version of the data.
+// Scheduled caches never expire, their entries being replaced by Refresh.
+type cache struct {
+	mu        sync.RWMutex
+	data      map[string]*cacheEntry
+	scheduled bool // whether the entries are refreshed by Refresh
+
+	flight singleflight.Group[version] // retrievals of missing data
+}
+
+type cacheEntry struct {
+	version
+	time       time.Time // time of retrieval of the data
+	refreshing bool      // whether a background refresh is in flight
+}
+
+func newCache() *cache {
+	return &cache{
+		data: make(map[string]*cacheEntry),
+	}
+}
+
+// get returns the version of the data stored under key, using fetch to
+// retrieve it when it is missing from the cache.
+// fetch is given the cached version of the data, if any, and returns
+// errNotModified when that version is still current.
+// get gives up waiting for missing data when ctx is done.
+func (c *cache) get(ctx context.Context, key string, fetch func(prev version) (version, error)) (version, error) {
+	c.mu.RLock()
+	entry, ok := c.data[key]
+	if ok && (c.scheduled || time.Since(entry.time) < CacheTTL) {
+		v := entry.version
+		c.mu.RUnlock()
+		recordLookup(key, "hit")
+		return v, nil
+	}
+	c.mu.RUnlock()
+
+	if ok {
+		recordLookup(key, "stale")
+		c.mu.Lock()
+		v := entry.version
+		if !entry.refreshing {
+			entry.refreshing = true
+			go c.refresh(key, entry.version, fetch)
+		}
+		c.mu.Unlock()
+		return v, nil
+	}
+
+	recordLookup(key, "miss")
+	// concurrent misses share a single retrieval.
+	v, err := c.flight.Do(ctx, key, func(context.Context) (version, error) {
+		v, err := fetch(version{})
+		if err != nil {
+			return version{}, err
+		}
+		c.set(key, v)
+		return v, nil
+	})
+	if err != nil {
+		return version{}, err
+	}
+	return v, nil
+}
+
+// refresh retrieves a new version of the data stored under key, prev.
+func (c *cache) refresh(key string, prev version, fetch func(prev version) (version, error)) {
+	v, err := fetch(prev)
+	switch {
+	case errors.Is(err, errNotModified):
+		c.set(key, prev)
+	case err != nil:
+		slog.Warn("could not refresh cached data", "key", key, "error", err)
+		c.mu.Lock()
+		c.data[key].refreshing = false
+		c.mu.Unlock()
+	default:
+		c.set(key, v)
+	}
+}
+
+// lookup returns the version of the data stored under key, if any.
+func (c *cache) lookup(key string) (version, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	entry, ok := c.data[key]
+	if !ok {
+		return version{}, false
+	}
+	return entry.version, true
+}
+
+// schedule marks the cache as refreshed by Refresh.
+func (c *cache) schedule() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.scheduled = true
+}
+
+func (c *cache) set(key string, v version) {
+	c.put(key, v, time.Now())
+}
+
+// put stores v under key, as retrieved at time t.
+func (c *cache) put(key string, v version, t time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.data[key] = &cacheEntry{
+		version: v,
+		time:    t,
+	}
+}
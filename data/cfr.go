@@ -0,0 +1,69 @@
+// Copyright 2020 The covid19 Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package data
+
+import (
+	"context"
+	"fmt"
+	"math"
+
+	"github.com/sbinet/covid19/internal/parallel"
+)
+
+// fetchCFR retrieves the case fatality ratio of the requested countries,
+// the deaths over the confirmed cases in percent, and trims each series
+// to the first day the confirmed cases reached cutoff.
+//
+// The ratio is missing (NaN) on the days without any confirmed case.
+func fetchCFR(ctx context.Context, cutoff float64, countries []string, opts Options) (Dataset, error) {
+	// retrieve the untrimmed series, so they share the same days.
+	var confirmed, deaths Dataset
+	err := parallel.Do(
+		func() (err error) {
+			confirmed, err = Fetch(ctx, "confirmed", 0, countries, opts)
+			if err != nil {
+				return fmt.Errorf("could not fetch confirmed cases: %w", err)
+			}
+			return nil
+		},
+		func() (err error) {
+			deaths, err = Fetch(ctx, "deaths", 0, countries, opts)
+			if err != nil {
+				return fmt.Errorf("could not fetch deaths: %w", err)
+			}
+			return nil
+		},
+	)
+	if err != nil {
+		return Dataset{}, err
+	}
+
+	ds := Dataset{
+		Date:    confirmed.Date,
+		Start:   confirmed.Start,
+		Table:   make(map[string][]float64, len(confirmed.Table)),
+		Cutoff:  make(map[string]int, len(confirmed.Table)),
+		Regions: confirmed.Regions,
+	}
+	for name, cases := range confirmed.Table {
+		vs := deaths.Table[name]
+		cfr := make([]float64, min(len(cases), len(vs)))
+		for i := range cfr {
+			if !(cases[i] > 0) {
+				cfr[i] = math.NaN()
+				continue
+			}
+			cfr[i] = 100 * vs[i] / cases[i]
+		}
+
+		idx, ok := CutoffIndex(cases, cutoff)
+		if ok {
+			ds.Cutoff[name] = idx
+		}
+		ds.Table[name] = cfr[min(idx, len(cfr)):]
+	}
+
+	return ds, nil
+}
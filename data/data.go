@@ -0,0 +1,483 @@
+// Copyright 2020 The covid19 Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package data retrieves the time series of the CoVid-19 cases, from the
+// CSSE at Johns Hopkins University or from other data sources, aligned on
+// the first day each series reached a cutoff.
+package data // import "github.com/sbinet/covid19/data"
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sbinet/covid19/internal/logctx"
+	"gonum.org/v1/gonum/floats"
+)
+
+var (
+	// Source is the base URL of the CSSE time series data files, used
+	// by the JHU data source. It may also be a local directory or a
+	// file:// URL.
+	Source = "https://raw.githubusercontent.com/CSSEGISandData/COVID-19/master/csse_covid_19_data/csse_covid_19_time_series"
+
+	// DownloadTimeout bounds the time spent retrieving a data file,
+	// retries included.
+	DownloadTimeout = 1 * time.Minute
+
+	// Attempts is the number of attempts of the download of a data file
+	// from a location, before falling back to the next mirror, if any.
+	Attempts = 3
+
+	// Backoff is the delay before the second attempt of a download,
+	// doubled after each failed attempt.
+	Backoff = 1 * time.Second
+
+	// Mirrors are base URLs, or local directories, holding copies of the
+	// data files under the names they are written with by Store. They
+	// are tried in order when the data files can not be retrieved from
+	// their location.
+	Mirrors []string
+
+	// Client is the client used to retrieve the data files.
+	// Its timeout bounds each attempt, so a stalled connection cannot
+	// hold a caller until the download timeout expires.
+	Client = &http.Client{Timeout: 30 * time.Second}
+
+	// ArchiveDir is the directory where downloaded data files are
+	// archived, if any.
+	ArchiveDir string
+
+	// Dir is the directory the data files are read from, as written by
+	// Store, instead of being downloaded from their data source.
+	Dir string
+)
+
+// Options selects the data retrieved from the data files.
+type Options struct {
+	Level     string    // geographic level of the series: "country", "state", "county" or "province"
+	Parent    string    // country of the provinces, at the "province" level
+	Until     time.Time // last day of data to consider, or zero for all the data
+	AsOf      time.Time // day of the archived version of the data to use, or zero for the current data
+	Negatives string    // policy for negative values: "clamp" (the default), "drop" or "keep"
+}
+
+// Dataset holds the series of a data file, trimmed at their cutoff.
+type Dataset struct {
+	Date  time.Time            // date of the latest data
+	Start time.Time            // date of the first day of the data file
+	Table map[string][]float64 // series, by country
+
+	// Cutoff holds the index in the data file of the first day each
+	// series reached the cutoff. Series that never reached it are
+	// missing from Cutoff and are kept untrimmed.
+	Cutoff map[string]int
+
+	// Regions holds the sorted names of the regions (provinces, or the
+	// counties of the US states) summed into each series, for the data
+	// files listing them.
+	Regions map[string][]string
+}
+
+// Reached returns whether the series of name reached the cutoff.
+func (ds Dataset) Reached(name string) bool {
+	_, ok := ds.Cutoff[name]
+	return ok
+}
+
+// Day returns the position of date in the series of name, in days.
+func (ds Dataset) Day(name string, date time.Time) float64 {
+	offset := 0 // untrimmed series start on the first day of the data.
+	if idx, ok := ds.Cutoff[name]; ok {
+		offset = idx
+	}
+	return date.Sub(ds.Start).Hours()/24 - float64(offset)
+}
+
+// Align trims each series of ds to the first day its value reached
+// cutoff, such as after normalizing the values.
+// The series must not be trimmed yet, as retrieved with a zero cutoff.
+func (ds *Dataset) Align(cutoff float64) {
+	for name, ys := range ds.Table {
+		idx, ok := CutoffIndex(ys, cutoff)
+		if ok {
+			ds.Cutoff[name] = idx
+		} else {
+			// series retrieved with a zero cutoff reached it.
+			delete(ds.Cutoff, name)
+		}
+		ds.Table[name] = ys[idx:]
+	}
+}
+
+// Fetch retrieves the title dataset ("confirmed", "deaths", "recovered",
+// "active" or "cfr") of the requested countries from Backend, and trims
+// each series to the first day its value reached cutoff.
+// The case fatality ratio, "cfr", is trimmed at the first day the
+// confirmed cases reached cutoff instead.
+// All the countries present in the data are collected when countries is nil.
+// At the country level, countries may also hold aggregates of countries,
+// as listed by Aggregates.
+func Fetch(ctx context.Context, title string, cutoff float64, countries []string, opts Options) (Dataset, error) {
+	// the data is of no use once the request is abandoned.
+	if err := ctx.Err(); err != nil {
+		return Dataset{}, err
+	}
+
+	switch title {
+	case "active":
+		return fetchActive(ctx, cutoff, countries, opts)
+	case "cfr":
+		return fetchCFR(ctx, cutoff, countries, opts)
+	}
+
+	if opts.Level == "" || opts.Level == "country" {
+		if slices.ContainsFunc(countries, isAggregate) {
+			dataset, err := fetchAggregates(ctx, title, countries, opts)
+			if err != nil {
+				return dataset, err
+			}
+			dataset.Align(cutoff)
+			return dataset, nil
+		}
+	}
+
+	dataset, err := Backend.Fetch(ctx, title, countries, opts)
+	if err != nil {
+		return dataset, err
+	}
+
+	cleanup(ctx, title, &dataset)
+	dataset.Align(cutoff)
+
+	return dataset, nil
+}
+
+// Latest returns the date of the latest data of the title dataset, as
+// reported by Fetch. It is read from the cached data files of Backend
+// when possible, instead of parsing them.
+func Latest(ctx context.Context, title string, opts Options) (time.Time, error) {
+	src, ok := Backend.(dater)
+	if !ok {
+		ds, err := Fetch(ctx, title, 0, nil, opts)
+		if err != nil {
+			return time.Time{}, err
+		}
+		return ds.Date, nil
+	}
+
+	switch title {
+	case "active", "cfr":
+		// the derived datasets are dated by their confirmed cases.
+		title = "confirmed"
+	}
+	return src.latest(ctx, title, opts)
+}
+
+// ParseCSV parses the CSSE time series CSV data from r, summing the
+// regions of each requested country and trimming each series to the
+// first day its value reached cutoff.
+// All the countries present in the data are collected when countries is nil.
+// With the "state" level, r holds the US data and the counties of each
+// requested state are summed instead, while they are collected as they
+// are with the "county" level, named as "County (State)".
+// With the "province" level, the provinces of the opts.Parent country are
+// collected as they are, its main territory being named after it.
+func ParseCSV(ctx context.Context, r io.Reader, title string, cutoff float64, countries []string, opts Options) (Dataset, error) {
+	var dataset = Dataset{
+		Table:  make(map[string][]float64, len(countries)),
+		Cutoff: make(map[string]int, len(countries)),
+	}
+
+	seen := make(map[string]bool)
+	parents := make(map[string]bool) // countries, at the "province" level
+	regions := make(map[string][]string)
+	raw := csv.NewReader(r)
+	raw.Comma = ','
+
+	hdr, err := raw.Read()
+	if err != nil {
+		return dataset, fmt.Errorf("could not read CSV header: %w", err)
+	}
+
+	col, sub, nmeta, err := csvLayout(hdr, opts.Level)
+	if err != nil {
+		return dataset, fmt.Errorf("invalid CSV header: %w", err)
+	}
+
+	const layout = "1/2/06"
+
+	sz := len(hdr) - nmeta
+	if !opts.Until.IsZero() {
+		// drop the days after until.
+		sz = 0
+		for _, v := range hdr[nmeta:] {
+			date, err := time.Parse(layout, v)
+			if err != nil {
+				return dataset, fmt.Errorf("could not parse date: %w", err)
+			}
+			if date.After(opts.Until) {
+				break
+			}
+			sz++
+		}
+		if sz == 0 {
+			return dataset, fmt.Errorf("no data until %s", opts.Until.Format("2006-01-02"))
+		}
+	}
+	for _, name := range countries {
+		dataset.Table[name] = make([]float64, sz)
+	}
+
+loop:
+	for {
+		rec, err := raw.Read()
+		if err != nil {
+			if err == io.EOF {
+				break loop
+			}
+			return dataset, fmt.Errorf("could not read CSV data: %w", err)
+		}
+
+		name := strings.TrimSpace(rec[col])
+		if opts.Level == "county" {
+			// territories without counties are named after themselves.
+			if county := strings.TrimSpace(rec[sub]); county != "" {
+				name = county + " (" + name + ")"
+			}
+		}
+		if opts.Level == "province" {
+			country := strings.TrimSpace(rec[1])
+			parents[country] = true
+			if country != opts.Parent {
+				continue
+			}
+			if name == "" {
+				name = country
+			}
+		}
+		seen[name] = true
+		if _, ok := dataset.Table[name]; !ok {
+			if countries != nil {
+				continue
+			}
+			dataset.Table[name] = make([]float64, sz)
+		}
+		if sub >= 0 && opts.Level != "county" {
+			if region := strings.TrimSpace(rec[sub]); region != "" {
+				regions[name] = append(regions[name], region)
+			}
+		}
+
+		rec = rec[nmeta : nmeta+sz]
+		var (
+			data = make([]float64, len(rec))
+			last float64 // latest finite value, carried over missing days
+		)
+		for i, str := range rec {
+			if str == "" {
+				// cumulative series are monotonic: carry the previous
+				// value forward instead of dipping to zero, or to a
+				// dropped value.
+				data[i] = last
+				continue
+			}
+			v, err := strconv.ParseFloat(str, 64)
+			if err != nil {
+				// a bad cell should not prevent the chart from being drawn:
+				// treat it as a missing value.
+				logctx.From(ctx).Warn(
+					"malformed value, carrying previous value forward",
+					"title", title, "country", name, "date", hdr[i+nmeta],
+					"value", str, "error", err,
+				)
+				data[i] = last
+				continue
+			}
+			if v < 0 {
+				logctx.From(ctx).Warn(
+					"negative value",
+					"title", title, "country", name, "date", hdr[i+nmeta],
+					"value", v, "policy", opts.Negatives,
+				)
+				switch opts.Negatives {
+				case "keep":
+				case "drop":
+					v = math.NaN()
+				default:
+					v = 0
+				}
+			} else if v < last {
+				logctx.From(ctx).Warn(
+					"decreasing value, upstream correction",
+					"title", title, "country", name, "date", hdr[i+nmeta],
+					"value", v, "previous", last,
+				)
+			}
+			data[i] = v
+			if !math.IsNaN(v) {
+				last = v
+			}
+		}
+		floats.Add(dataset.Table[name], data)
+	}
+
+	if opts.Level == "province" && !parents[opts.Parent] {
+		known := make([]string, 0, len(parents))
+		for name := range parents {
+			known = append(known, name)
+		}
+		return dataset, unknownCountries([]string{opts.Parent}, known)
+	}
+
+	var unknown []string
+	for _, name := range countries {
+		if !seen[name] {
+			unknown = append(unknown, name)
+		}
+	}
+	if len(unknown) > 0 {
+		known := make([]string, 0, len(seen))
+		for name := range seen {
+			known = append(known, name)
+		}
+		if opts.Level == "" || opts.Level == "country" {
+			known = append(known, Aggregates()...)
+		}
+		return dataset, unknownCountries(unknown, known)
+	}
+
+	dataset.Align(cutoff)
+
+	if len(regions) > 0 {
+		dataset.Regions = make(map[string][]string, len(regions))
+		for name, vs := range regions {
+			sort.Strings(vs)
+			dataset.Regions[name] = vs
+		}
+	}
+
+	for _, v := range []struct {
+		input  string
+		output *time.Time
+	}{
+		{hdr[nmeta], &dataset.Start},
+		{hdr[nmeta+sz-1], &dataset.Date},
+	} {
+		date, err := time.Parse(layout, v.input)
+		if err != nil {
+			return dataset, fmt.Errorf("could not parse date: %w", err)
+		}
+		*v.output = date
+	}
+
+	return dataset, nil
+}
+
+// csvLayout returns the index of the column holding the region name, of
+// the column holding the names of the subregions summed into it (or -1),
+// and the number of metadata columns preceding the dates in the header
+// of a CSSE time series file of the given level.
+//
+// The global files hold 4 metadata columns (Province/State, Country/Region,
+// Lat, Long), while the US files hold 11 of them, or 12 with Population,
+// the counties being listed in the Admin2 column.
+// At the "province" level, the regions are the provinces of the global files.
+func csvLayout(hdr []string, level string) (col, sub, nmeta int, err error) {
+	switch level {
+	case "state", "county":
+		col, sub, nmeta = -1, -1, -1
+		for i, v := range hdr {
+			switch v {
+			case "Province_State":
+				col = i
+			case "Admin2":
+				sub = i
+			}
+			if _, err := time.Parse("1/2/06", v); err == nil {
+				nmeta = i
+				break
+			}
+		}
+		if col < 0 {
+			return 0, 0, 0, fmt.Errorf("missing Province_State column")
+		}
+		if sub < 0 && level == "county" {
+			return 0, 0, 0, fmt.Errorf("missing Admin2 column")
+		}
+		if nmeta < 0 {
+			return 0, 0, 0, fmt.Errorf("missing date columns")
+		}
+	default:
+		col, sub, nmeta = 1, 0, 4
+		if level == "province" {
+			col, sub = 0, -1
+		}
+		if len(hdr) <= nmeta {
+			return 0, 0, 0, fmt.Errorf("got %d columns, want at least %d", len(hdr), nmeta+1)
+		}
+	}
+	return col, sub, nmeta, nil
+}
+
+// UnknownCountriesError is returned when requested countries are not
+// present in the data.
+type UnknownCountriesError struct {
+	Names []string
+
+	// Matches holds the names present in the data that are close to
+	// each unknown name, if any.
+	Matches map[string][]string
+}
+
+// unknownCountries returns the error reporting the unknown names, with
+// the close matches among the known ones of the first maxSuggested of them.
+func unknownCountries(names, known []string) *UnknownCountriesError {
+	// the matches of every name of a long list would take a long time
+	// to compute, for an error message no one reads in full.
+	const maxSuggested = 5
+
+	err := &UnknownCountriesError{Names: names}
+	for _, name := range names[:min(len(names), maxSuggested)] {
+		if ms := closeMatches(name, known); len(ms) > 0 {
+			if err.Matches == nil {
+				err.Matches = make(map[string][]string)
+			}
+			err.Matches[name] = ms
+		}
+	}
+	return err
+}
+
+func (err *UnknownCountriesError) Error() string {
+	names := make([]string, len(err.Names))
+	for i, name := range err.Names {
+		names[i] = name
+		if ms := err.Matches[name]; len(ms) > 0 {
+			names[i] += fmt.Sprintf(" (did you mean %s?)", strings.Join(ms, ", "))
+		}
+	}
+	return fmt.Sprintf("unknown countries: %s", strings.Join(names, ", "))
+}
+
+// CutoffIndex returns the index of the first day data reached cutoff,
+// and whether it was reached at all.
+// When reached, data[idx] >= cutoff and all the values before idx are
+// below cutoff, so series trimmed at idx are aligned on the same threshold.
+func CutoffIndex(data []float64, cutoff float64) (idx int, ok bool) {
+	for i, v := range data {
+		if v >= cutoff {
+			return i, true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,378 @@
+// Copyright 2020 The covid19 Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package data
+
+import (
+	"bytes"
+	"context"
+	"math"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseCSV(t *testing.T) {
+	raw, err := os.ReadFile("testdata/time_series_covid19_confirmed_global.csv")
+	if err != nil {
+		t.Fatalf("could not read fixture: %+v", err)
+	}
+
+	ds, err := ParseCSV(
+		context.Background(), bytes.NewReader(raw), "confirmed", 10,
+		[]string{"France", "Italy", "China"}, Options{},
+	)
+	if err != nil {
+		t.Fatalf("could not parse CSV: %+v", err)
+	}
+
+	for _, tc := range []struct {
+		name   string
+		cutoff int
+		want   []float64
+	}{
+		// the main territory and Reunion are summed, the empty cell of
+		// the former carrying its previous value forward.
+		{"France", 3, []float64{23, 44}},
+		{"Italy", 4, []float64{200}},
+		{"China", 0, []float64{110, 220, 330, 440, 550}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got, ok := ds.Cutoff[tc.name]; !ok || got != tc.cutoff {
+				t.Fatalf("invalid cutoff index: got=%d (reached=%v), want=%d", got, ok, tc.cutoff)
+			}
+			if got := ds.Table[tc.name]; !reflect.DeepEqual(got, tc.want) {
+				t.Fatalf("invalid series:\ngot= %v\nwant=%v", got, tc.want)
+			}
+		})
+	}
+
+	if got, want := ds.Regions, map[string][]string{
+		"France": {"Reunion"},
+		"China":  {"Beijing", "Hubei"},
+	}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("invalid regions:\ngot= %v\nwant=%v", got, want)
+	}
+
+	if got, want := ds.Start, time.Date(2020, 1, 22, 0, 0, 0, 0, time.UTC); !got.Equal(want) {
+		t.Fatalf("invalid start date: got=%v, want=%v", got, want)
+	}
+	if got, want := ds.Date, time.Date(2020, 1, 26, 0, 0, 0, 0, time.UTC); !got.Equal(want) {
+		t.Fatalf("invalid date: got=%v, want=%v", got, want)
+	}
+}
+
+func TestParseCSVNegatives(t *testing.T) {
+	raw, err := os.ReadFile("testdata/negatives.csv")
+	if err != nil {
+		t.Fatalf("could not read fixture: %+v", err)
+	}
+
+	nan := math.NaN()
+	for _, tc := range []struct {
+		policy string
+		want   map[string][]float64
+	}{
+		{"", map[string][]float64{
+			"Spain":    {10, 20, 0, 30},
+			"Portugal": {10, 0, 0, 30},
+		}},
+		{"clamp", map[string][]float64{
+			"Spain":    {10, 20, 0, 30},
+			"Portugal": {10, 0, 0, 30},
+		}},
+		// the missing day after a dropped value carries the last
+		// finite value forward.
+		{"drop", map[string][]float64{
+			"Spain":    {10, 20, nan, 30},
+			"Portugal": {10, nan, 10, 30},
+		}},
+		{"keep", map[string][]float64{
+			"Spain":    {10, 20, -5, 30},
+			"Portugal": {10, -5, -5, 30},
+		}},
+	} {
+		name := tc.policy
+		if name == "" {
+			name = "default"
+		}
+		t.Run(name, func(t *testing.T) {
+			ds, err := ParseCSV(
+				context.Background(), bytes.NewReader(raw), "deaths", 0,
+				[]string{"Spain", "Portugal"}, Options{Negatives: tc.policy},
+			)
+			if err != nil {
+				t.Fatalf("could not parse CSV: %+v", err)
+			}
+			for country, want := range tc.want {
+				got := ds.Table[country]
+				if len(got) != len(want) {
+					t.Fatalf("invalid %s series:\ngot= %v\nwant=%v", country, got, want)
+				}
+				for i := range got {
+					if got[i] != want[i] && !(math.IsNaN(got[i]) && math.IsNaN(want[i])) {
+						t.Fatalf("invalid %s series:\ngot= %v\nwant=%v", country, got, want)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestRecordsNegatives(t *testing.T) {
+	start := time.Date(2020, 4, 1, 0, 0, 0, 0, time.UTC)
+	recs := make(records)
+	recs.add("Portugal", start, 10)
+	recs.add("Portugal", start.AddDate(0, 0, 1), -5)
+	// the third day is missing.
+	recs.add("Portugal", start.AddDate(0, 0, 3), 30)
+
+	ds, err := recs.dataset(context.Background(), "deaths", []string{"Portugal"}, Options{Negatives: "drop"})
+	if err != nil {
+		t.Fatalf("could not create dataset: %+v", err)
+	}
+	got := ds.Table["Portugal"]
+	if len(got) != 4 || got[0] != 10 || !math.IsNaN(got[1]) || got[2] != 10 || got[3] != 30 {
+		t.Fatalf("invalid series: got=%v, want=[10 NaN 10 30]", got)
+	}
+}
+
+func TestParseCSVGaps(t *testing.T) {
+	const raw = `Province/State,Country/Region,Lat,Long,4/1/20,4/2/20,4/3/20,4/4/20,4/5/20
+,Spain,40.4637,-3.7492,10,20,,30,40
+,Portugal,39.3999,-8.2245,1,,,4,5
+,Greece,39.0742,21.8243,,2,3,4,
+`
+	ds, err := ParseCSV(
+		context.Background(), strings.NewReader(raw), "confirmed", 0,
+		[]string{"Spain", "Portugal", "Greece"}, Options{},
+	)
+	if err != nil {
+		t.Fatalf("could not parse CSV: %+v", err)
+	}
+
+	for _, tc := range []struct {
+		name string
+		want []float64
+	}{
+		{"Spain", []float64{10, 20, 20, 30, 40}},
+		{"Portugal", []float64{1, 1, 1, 4, 5}},
+		// nothing is carried forward on the first day.
+		{"Greece", []float64{0, 2, 3, 4, 4}},
+	} {
+		if got := ds.Table[tc.name]; !reflect.DeepEqual(got, tc.want) {
+			t.Fatalf("invalid %s series:\ngot= %v\nwant=%v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestParseCSVMalformed(t *testing.T) {
+	const raw = `Province/State,Country/Region,Lat,Long,4/1/20,4/2/20,4/3/20,4/4/20
+,Spain,40.4637,-3.7492,10,20,2x5,30
+,Portugal,39.3999,-8.2245,n/a,2,3,4
+`
+	ds, err := ParseCSV(
+		context.Background(), strings.NewReader(raw), "confirmed", 0,
+		[]string{"Spain", "Portugal"}, Options{},
+	)
+	if err != nil {
+		t.Fatalf("could not parse CSV: %+v", err)
+	}
+
+	// malformed values are treated as missing ones.
+	for _, tc := range []struct {
+		name string
+		want []float64
+	}{
+		{"Spain", []float64{10, 20, 20, 30}},
+		{"Portugal", []float64{0, 2, 3, 4}},
+	} {
+		if got := ds.Table[tc.name]; !reflect.DeepEqual(got, tc.want) {
+			t.Fatalf("invalid %s series:\ngot= %v\nwant=%v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestCutoffIndex(t *testing.T) {
+	const cutoff = 100
+
+	for _, tc := range []struct {
+		name    string
+		data    []float64
+		idx     int
+		reached bool
+	}{
+		{"first-day", []float64{100, 150, 200}, 0, true},
+		{"crossing", []float64{10, 50, 99, 130, 180}, 3, true},
+		{"exact", []float64{0, 20, 100, 100, 140}, 2, true},
+		{"jump", []float64{0, 0, 0, 5000}, 3, true},
+		{"never", []float64{0, 10, 20, 99}, 0, false},
+		{"empty", nil, 0, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			idx, ok := CutoffIndex(tc.data, cutoff)
+			if idx != tc.idx || ok != tc.reached {
+				t.Fatalf("invalid cutoff index: got=(%d, %v), want=(%d, %v)", idx, ok, tc.idx, tc.reached)
+			}
+			if !ok {
+				return
+			}
+			if tc.data[idx] < cutoff {
+				t.Fatalf("series starts below cutoff: %v", tc.data[idx])
+			}
+			for _, v := range tc.data[:idx] {
+				if v >= cutoff {
+					t.Fatalf("series reached cutoff before index %d: %v", idx, v)
+				}
+			}
+		})
+	}
+}
+
+func TestAlign(t *testing.T) {
+	const cutoff = 100
+
+	raw := map[string][]float64{
+		"first-day": {100, 150, 200},
+		"crossing":  {10, 50, 99, 130, 180},
+		"exact":     {0, 20, 100, 100, 140},
+		"jump":      {0, 0, 0, 5000},
+		"never":     {0, 10, 20, 99},
+		"empty":     nil,
+	}
+	ds := Dataset{
+		Table:  make(map[string][]float64, len(raw)),
+		Cutoff: make(map[string]int),
+	}
+	for name, ys := range raw {
+		ds.Table[name] = append([]float64(nil), ys...)
+	}
+	// the series are retrieved with a zero cutoff before being aligned.
+	ds.Align(0)
+	ds.Align(cutoff)
+
+	for _, tc := range []struct {
+		name    string
+		reached bool
+	}{
+		{"first-day", true},
+		{"crossing", true},
+		{"exact", true},
+		{"jump", true},
+		{"never", false},
+		{"empty", false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			raw := raw[tc.name]
+			idx, ok := CutoffIndex(raw, cutoff)
+			if ok != tc.reached {
+				t.Fatalf("invalid reached index: got=%v, want=%v", ok, tc.reached)
+			}
+			if got := ds.Reached(tc.name); got != tc.reached {
+				t.Fatalf("invalid reached dataset: got=%v, want=%v", got, tc.reached)
+			}
+
+			ys := ds.Table[tc.name]
+			if !tc.reached {
+				if !reflect.DeepEqual(ys, raw) {
+					t.Fatalf("series trimmed:\ngot= %v\nwant=%v", ys, raw)
+				}
+				return
+			}
+
+			if got := ds.Cutoff[tc.name]; got != idx {
+				t.Fatalf("invalid cutoff index: got=%d, want=%d", got, idx)
+			}
+			if ys[0] < cutoff {
+				t.Fatalf("series starts below cutoff: %v", ys[0])
+			}
+			if idx > 0 && raw[idx-1] >= cutoff {
+				t.Fatalf("series reached cutoff before index %d: %v", idx, raw[idx-1])
+			}
+			if got, want := len(ys), len(raw)-idx; got != want {
+				t.Fatalf("invalid trimmed length: got=%d, want=%d", got, want)
+			}
+		})
+	}
+}
+
+func TestDatasetReached(t *testing.T) {
+	const raw = `Province/State,Country/Region,Lat,Long,3/1/20,3/2/20,3/3/20,3/4/20
+,France,46.2276,2.2137,20,60,130,180
+,Monaco,43.7333,7.4167,0,1,1,2
+`
+	ds, err := ParseCSV(
+		context.Background(), strings.NewReader(raw), "confirmed", 100,
+		[]string{"France", "Monaco"}, Options{},
+	)
+	if err != nil {
+		t.Fatalf("could not parse CSV: %+v", err)
+	}
+
+	date := time.Date(2020, 3, 3, 0, 0, 0, 0, time.UTC)
+	for _, tc := range []struct {
+		name    string
+		reached bool
+		day     float64
+		want    []float64
+	}{
+		{"France", true, 0, []float64{130, 180}},
+		// the low counts of Monaco never reach the cutoff, and are kept
+		// untrimmed.
+		{"Monaco", false, 2, []float64{0, 1, 1, 2}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := ds.Reached(tc.name); got != tc.reached {
+				t.Fatalf("invalid reached: got=%v, want=%v", got, tc.reached)
+			}
+			if got := ds.Day(tc.name, date); got != tc.day {
+				t.Fatalf("invalid day: got=%v, want=%v", got, tc.day)
+			}
+			if got := ds.Table[tc.name]; !reflect.DeepEqual(got, tc.want) {
+				t.Fatalf("invalid series:\ngot= %v\nwant=%v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestLatest(t *testing.T) {
+	orig := Dir
+	Dir = "testdata"
+	t.Cleanup(func() { Dir = orig })
+
+	ctx := context.Background()
+	for _, tc := range []struct {
+		title string
+		opts  Options
+		want  time.Time
+	}{
+		{"confirmed", Options{}, time.Date(2020, 1, 26, 0, 0, 0, 0, time.UTC)},
+		{"cfr", Options{}, time.Date(2020, 1, 26, 0, 0, 0, 0, time.UTC)},
+		{
+			"confirmed", Options{Until: time.Date(2020, 1, 24, 0, 0, 0, 0, time.UTC)},
+			time.Date(2020, 1, 24, 0, 0, 0, 0, time.UTC),
+		},
+	} {
+		t.Run(tc.title, func(t *testing.T) {
+			got, err := Latest(ctx, tc.title, tc.opts)
+			if err != nil {
+				t.Fatalf("could not retrieve latest date: %+v", err)
+			}
+			if !got.Equal(tc.want) {
+				t.Fatalf("invalid date: got=%v, want=%v", got, tc.want)
+			}
+		})
+	}
+
+	// the date is kept with the cached data file.
+	v, ok := dataCache.lookup("confirmed_global")
+	if !ok {
+		t.Fatalf("data file not cached")
+	}
+	if want := time.Date(2020, 1, 26, 0, 0, 0, 0, time.UTC); !v.date.Equal(want) {
+		t.Fatalf("invalid cached date: got=%v, want=%v", v.date, want)
+	}
+}
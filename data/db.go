@@ -0,0 +1,221 @@
+// Copyright 2020 The covid19 Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package data
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/sbinet/covid19/internal/logctx"
+)
+
+// DB is a data source persisting the series of an upstream data source
+// in an SQLite database, and serving them from it.
+//
+// The stored series are updated at most every CacheTTL, only adding the
+// days published since the previous update. When the upstream data source
+// is unreachable, the stored series are served as they are.
+type DB struct {
+	db   *sql.DB
+	name string     // name of the upstream data source
+	src  DataSource // upstream data source
+
+	mu      sync.Mutex              // guards updated, and serializes the writes of the stored series
+	updated map[[2]string]time.Time // time of the last update, by title and level
+}
+
+// NewDB returns a data source persisting the series of the data source
+// src, named name, in db. The tables of db are created if needed.
+func NewDB(ctx context.Context, db *sql.DB, name string, src DataSource) (*DB, error) {
+	_, err := db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS series (
+	source  TEXT NOT NULL,
+	title   TEXT NOT NULL,
+	level   TEXT NOT NULL,
+	country TEXT NOT NULL,
+	date    TEXT NOT NULL,
+	value   REAL NOT NULL,
+	PRIMARY KEY (source, title, level, country, date)
+)`)
+	if err != nil {
+		return nil, fmt.Errorf("could not create series table: %w", err)
+	}
+	return &DB{
+		db:      db,
+		name:    name,
+		src:     src,
+		updated: make(map[[2]string]time.Time),
+	}, nil
+}
+
+func (db *DB) Fetch(ctx context.Context, title string, countries []string, opts Options) (Dataset, error) {
+	// archived data, and the provinces of a country, are not persisted.
+	if !opts.AsOf.IsZero() || opts.Level == "province" {
+		return db.src.Fetch(ctx, title, countries, opts)
+	}
+
+	level := opts.Level
+	if level == "" {
+		level = "country"
+	}
+
+	err := db.update(ctx, title, level)
+	if err != nil {
+		return Dataset{}, err
+	}
+
+	rows, err := db.db.QueryContext(
+		ctx,
+		"SELECT country, date, value FROM series WHERE source = ? AND title = ? AND level = ?",
+		db.name, title, level,
+	)
+	if err != nil {
+		return Dataset{}, fmt.Errorf("could not query series: %w", err)
+	}
+	defer rows.Close()
+
+	recs := make(records)
+	for rows.Next() {
+		var (
+			name string
+			day  string
+			v    float64
+		)
+		err := rows.Scan(&name, &day, &v)
+		if err != nil {
+			return Dataset{}, fmt.Errorf("could not read series: %w", err)
+		}
+		date, err := time.Parse("2006-01-02", day)
+		if err != nil {
+			return Dataset{}, fmt.Errorf("could not parse date: %w", err)
+		}
+		recs.add(name, date, v)
+	}
+	if err := rows.Err(); err != nil {
+		return Dataset{}, fmt.Errorf("could not read series: %w", err)
+	}
+
+	return recs.dataset(ctx, title, countries, opts)
+}
+
+func (db *DB) files() []dataFile {
+	if fs, ok := db.src.(filer); ok {
+		return fs.files()
+	}
+	return nil
+}
+
+// update stores the days of the title dataset of level published since
+// the previous update, unless it was updated less than CacheTTL ago.
+// Failures are only logged once series are stored, so they keep being
+// served when the upstream data source is unreachable.
+//
+// The upstream data source is fetched without holding db.mu, so a stalled
+// download does not block the requests served from the stored series.
+func (db *DB) update(ctx context.Context, title, level string) error {
+	key := [2]string{title, level}
+	db.mu.Lock()
+	fresh := time.Since(db.updated[key]) < CacheTTL
+	db.mu.Unlock()
+	if fresh {
+		return nil
+	}
+
+	// the values are stored as published, the policy for negative values
+	// being applied when they are served.
+	ds, err := db.src.Fetch(ctx, title, nil, Options{Level: level, Negatives: "keep"})
+	if err != nil {
+		err = fmt.Errorf("could not fetch %s series: %w", title, err)
+	} else {
+		err = db.ingest(ctx, title, level, ds)
+	}
+	if err == nil {
+		db.mu.Lock()
+		db.updated[key] = time.Now()
+		db.mu.Unlock()
+		return nil
+	}
+
+	var n int
+	row := db.db.QueryRowContext(
+		ctx,
+		"SELECT COUNT(*) FROM series WHERE source = ? AND title = ? AND level = ?",
+		db.name, title, level,
+	)
+	if row.Scan(&n) != nil || n == 0 {
+		return err
+	}
+	logctx.From(ctx).Warn("could not update stored series", "title", title, "level", level, "error", err)
+	return nil
+}
+
+// ingest stores the days of the series of ds, the title dataset of level,
+// that are more recent than the latest stored day of each country.
+func (db *DB) ingest(ctx context.Context, title, level string, ds Dataset) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	latest := make(map[string]string)
+	rows, err := db.db.QueryContext(
+		ctx,
+		"SELECT country, MAX(date) FROM series WHERE source = ? AND title = ? AND level = ? GROUP BY country",
+		db.name, title, level,
+	)
+	if err != nil {
+		return fmt.Errorf("could not query latest stored days: %w", err)
+	}
+	for rows.Next() {
+		var name, day string
+		if err := rows.Scan(&name, &day); err != nil {
+			rows.Close()
+			return fmt.Errorf("could not read latest stored days: %w", err)
+		}
+		latest[name] = day
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("could not read latest stored days: %w", err)
+	}
+
+	tx, err := db.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("could not begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.PrepareContext(
+		ctx,
+		"INSERT OR IGNORE INTO series (source, title, level, country, date, value) VALUES (?, ?, ?, ?, ?, ?)",
+	)
+	if err != nil {
+		return fmt.Errorf("could not prepare statement: %w", err)
+	}
+	defer stmt.Close()
+
+	n := 0
+	for name, ys := range ds.Table {
+		for i, v := range ys {
+			// ISO dates sort lexically.
+			day := ds.Start.AddDate(0, 0, i).Format("2006-01-02")
+			if day <= latest[name] {
+				continue
+			}
+			_, err := stmt.ExecContext(ctx, db.name, title, level, name, day, v)
+			if err != nil {
+				return fmt.Errorf("could not store %s series of %s: %w", title, name, err)
+			}
+			n++
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("could not commit transaction: %w", err)
+	}
+	logctx.From(ctx).Info("stored series updated", "title", title, "level", level, "days", n)
+	return nil
+}
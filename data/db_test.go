@@ -0,0 +1,286 @@
+// Copyright 2020 The covid19 Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package data
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDB(t *testing.T) {
+	orig := CacheTTL
+	CacheTTL = 0 // update the stored series on each fetch.
+	t.Cleanup(func() { CacheTTL = orig })
+
+	var (
+		ctx   = context.Background()
+		start = time.Date(2020, 3, 1, 0, 0, 0, 0, time.UTC)
+		mem   = &memDB{rows: make(map[memKey]float64)}
+		src   = &memSource{}
+		sqldb = sql.OpenDB(memConnector{mem})
+	)
+	defer sqldb.Close()
+
+	db, err := NewDB(ctx, sqldb, "jhu", src)
+	if err != nil {
+		t.Fatalf("could not create database: %+v", err)
+	}
+
+	fetch := func(t *testing.T, want map[string][]float64, inserts int) {
+		t.Helper()
+		ds, err := db.Fetch(ctx, "confirmed", []string{"France", "Italy"}, Options{})
+		if err != nil {
+			t.Fatalf("could not fetch series: %+v", err)
+		}
+		if !ds.Start.Equal(start) {
+			t.Fatalf("invalid start date: got=%v, want=%v", ds.Start, start)
+		}
+		if !reflect.DeepEqual(ds.Table, want) {
+			t.Fatalf("invalid series:\ngot= %v\nwant=%v", ds.Table, want)
+		}
+		if got := mem.inserts; got != inserts {
+			t.Fatalf("invalid number of stored days: got=%d, want=%d", got, inserts)
+		}
+	}
+
+	src.ds = Dataset{
+		Start: start,
+		Table: map[string][]float64{
+			"France": {1, 2, 3},
+			"Italy":  {0, 5, 10},
+		},
+	}
+	fetch(t, map[string][]float64{
+		"France": {1, 2, 3},
+		"Italy":  {0, 5, 10},
+	}, 6)
+
+	// only the days published since the previous update are stored:
+	// the upstream revision of the first day of France is ignored.
+	src.ds = Dataset{
+		Start: start,
+		Table: map[string][]float64{
+			"France": {100, 2, 3, 4, 5},
+			"Italy":  {0, 5, 10, 15, 20},
+		},
+	}
+	fetch(t, map[string][]float64{
+		"France": {1, 2, 3, 4, 5},
+		"Italy":  {0, 5, 10, 15, 20},
+	}, 10)
+
+	// the stored series are served while the data source is unreachable.
+	src.err = errors.New("data source unreachable")
+	fetch(t, map[string][]float64{
+		"France": {1, 2, 3, 4, 5},
+		"Italy":  {0, 5, 10, 15, 20},
+	}, 10)
+
+	// unless none were stored.
+	other, err := NewDB(ctx, sqldb, "owid", src)
+	if err != nil {
+		t.Fatalf("could not create database: %+v", err)
+	}
+	_, err = other.Fetch(ctx, "confirmed", []string{"France"}, Options{})
+	if !errors.Is(err, src.err) {
+		t.Fatalf("invalid error: got=%v, want=%v", err, src.err)
+	}
+}
+
+func TestDBStalledFetch(t *testing.T) {
+	var (
+		ctx   = context.Background()
+		mem   = &memDB{rows: make(map[memKey]float64)}
+		sqldb = sql.OpenDB(memConnector{mem})
+		src   = &stallSource{
+			ds: Dataset{
+				Start: time.Date(2020, 3, 1, 0, 0, 0, 0, time.UTC),
+				Table: map[string][]float64{"France": {1, 2, 3}},
+			},
+			stalled: make(chan struct{}),
+			release: make(chan struct{}),
+		}
+	)
+	defer sqldb.Close()
+
+	db, err := NewDB(ctx, sqldb, "jhu", src)
+	if err != nil {
+		t.Fatalf("could not create database: %+v", err)
+	}
+
+	errc := make(chan error, 1)
+	go func() {
+		_, err := db.Fetch(ctx, "deaths", []string{"France"}, Options{})
+		errc <- err
+	}()
+	<-src.stalled
+
+	// the series of the other titles are updated and served meanwhile.
+	done := make(chan error, 1)
+	go func() {
+		_, err := db.Fetch(ctx, "confirmed", []string{"France"}, Options{})
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("could not fetch series: %+v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatalf("fetch blocked by the stalled update of another title")
+	}
+
+	close(src.release)
+	if err := <-errc; err != nil {
+		t.Fatalf("could not fetch stalled series: %+v", err)
+	}
+}
+
+// stallSource is a data source serving a fixed dataset, whose deaths
+// series are only served once release is closed.
+type stallSource struct {
+	ds      Dataset
+	stalled chan struct{} // closed once the deaths series are requested
+	release chan struct{}
+}
+
+func (src *stallSource) Fetch(ctx context.Context, title string, countries []string, opts Options) (Dataset, error) {
+	if title == "deaths" {
+		close(src.stalled)
+		<-src.release
+	}
+	return src.ds, nil
+}
+
+// memSource is a data source serving a fixed dataset.
+type memSource struct {
+	ds  Dataset
+	err error
+}
+
+func (src *memSource) Fetch(ctx context.Context, title string, countries []string, opts Options) (Dataset, error) {
+	return src.ds, src.err
+}
+
+// memDB is an in-memory series table, served through a database/sql
+// driver only supporting the statements of DB.
+type memDB struct {
+	mu      sync.Mutex
+	rows    map[memKey]float64
+	inserts int // number of inserted rows
+}
+
+type memKey struct {
+	source, title, level, country, date string
+}
+
+type memConnector struct{ db *memDB }
+
+func (c memConnector) Connect(context.Context) (driver.Conn, error) { return memConn(c), nil }
+func (c memConnector) Driver() driver.Driver                        { return c }
+func (c memConnector) Open(string) (driver.Conn, error)             { return memConn(c), nil }
+
+type memConn struct{ db *memDB }
+
+func (c memConn) Prepare(query string) (driver.Stmt, error) {
+	return memStmt{db: c.db, query: query}, nil
+}
+func (c memConn) Close() error              { return nil }
+func (c memConn) Begin() (driver.Tx, error) { return memTx{}, nil }
+
+// memTx applies the statements as they are executed.
+type memTx struct{}
+
+func (memTx) Commit() error   { return nil }
+func (memTx) Rollback() error { return nil }
+
+type memStmt struct {
+	db    *memDB
+	query string
+}
+
+func (s memStmt) Close() error  { return nil }
+func (s memStmt) NumInput() int { return -1 }
+
+func (s memStmt) Exec(args []driver.Value) (driver.Result, error) {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	switch {
+	case strings.HasPrefix(s.query, "CREATE TABLE"):
+		return driver.RowsAffected(0), nil
+	case strings.HasPrefix(s.query, "INSERT OR IGNORE"):
+		key := memKey{
+			args[0].(string), args[1].(string), args[2].(string),
+			args[3].(string), args[4].(string),
+		}
+		if _, dup := s.db.rows[key]; dup {
+			return driver.RowsAffected(0), nil
+		}
+		s.db.rows[key] = args[5].(float64)
+		s.db.inserts++
+		return driver.RowsAffected(1), nil
+	}
+	return nil, fmt.Errorf("unsupported statement %q", s.query)
+}
+
+func (s memStmt) Query(args []driver.Value) (driver.Rows, error) {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	var (
+		rows   [][]driver.Value
+		latest = make(map[string]string)
+	)
+	for k, v := range s.db.rows {
+		if k.source != args[0] || k.title != args[1] || k.level != args[2] {
+			continue
+		}
+		rows = append(rows, []driver.Value{k.country, k.date, v})
+		if k.date > latest[k.country] {
+			latest[k.country] = k.date
+		}
+	}
+
+	switch {
+	case strings.HasPrefix(s.query, "SELECT country, date, value"):
+		return &memRows{cols: []string{"country", "date", "value"}, rows: rows}, nil
+	case strings.HasPrefix(s.query, "SELECT COUNT(*)"):
+		return &memRows{cols: []string{"n"}, rows: [][]driver.Value{{int64(len(rows))}}}, nil
+	case strings.HasPrefix(s.query, "SELECT country, MAX(date)"):
+		r := &memRows{cols: []string{"country", "date"}}
+		for name, day := range latest {
+			r.rows = append(r.rows, []driver.Value{name, day})
+		}
+		return r, nil
+	}
+	return nil, fmt.Errorf("unsupported query %q", s.query)
+}
+
+type memRows struct {
+	cols []string
+	rows [][]driver.Value
+}
+
+func (r *memRows) Columns() []string { return r.cols }
+func (r *memRows) Close() error      { return nil }
+
+func (r *memRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}
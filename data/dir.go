@@ -0,0 +1,143 @@
+// Copyright 2020 The covid19 Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package data
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/sbinet/covid19/internal/logctx"
+)
+
+// dataFile describes a data file of a data source.
+type dataFile struct {
+	key  string // key of the file in the data cache and the archive
+	name string // name of the file in the data directory
+	url  string // location of the file at its data source
+
+	titles []string // datasets held by the file
+	level  string   // geographic level of the series of the file
+
+	// latest returns the date of the latest data of the file.
+	latest func(raw []byte) (time.Time, error)
+
+	// parse parses the title dataset of the requested countries from
+	// the file.
+	parse func(ctx context.Context, raw []byte, title string, countries []string, opts Options) (Dataset, error)
+}
+
+// location returns the location the data file is read from: its path in
+// Dir, when set, or its URL.
+func (f dataFile) location() string {
+	if Dir != "" {
+		return filepath.Join(Dir, f.name)
+	}
+	return f.url
+}
+
+// download retrieves the data file from its location, or from Mirrors
+// when that fails, unless it did not change since prev, the cached
+// version of the file, if any.
+// Downloaded files are archived under the date of their latest data.
+func (f dataFile) download(ctx context.Context, prev version) (version, error) {
+	start := time.Now()
+	v, err := f.fetch(ctx, prev)
+	if errors.Is(err, errNotModified) {
+		// the data source was reached: this is not a failed download.
+		recordFetch(f.key, start, nil)
+	} else {
+		recordFetch(f.key, start, err)
+	}
+	if err == nil && ArchiveDir != "" {
+		if err := archive(ctx, f.key, v.raw, f.latest); err != nil {
+			logctx.From(ctx).Warn("could not archive data", "key", f.key, "error", err)
+		}
+	}
+	return v, err
+}
+
+// fetch retrieves the data file from the first of its location and
+// Mirrors that serves a valid file.
+// A file without any latest date, such as a truncated file, is not valid.
+func (f dataFile) fetch(ctx context.Context, prev version) (version, error) {
+	locs := []string{f.location()}
+	for _, m := range Mirrors {
+		locs = append(locs, strings.TrimRight(m, "/")+"/"+f.name)
+	}
+
+	var errs []error
+	for i, loc := range locs {
+		// the validators of a version only apply to its location.
+		if i > 0 {
+			prev = version{}
+		}
+		v, err := download(ctx, loc, prev)
+		if err == nil {
+			if v.date, err = f.latest(v.raw); err != nil {
+				err = fmt.Errorf("invalid data file %q: %w", loc, err)
+			}
+		}
+		if err == nil || errors.Is(err, errNotModified) {
+			if i > 0 {
+				logctx.From(ctx).Info("data file retrieved from mirror", "key", f.key, "url", loc)
+			}
+			return v, err
+		}
+		errs = append(errs, err)
+		if i+1 < len(locs) {
+			logctx.From(ctx).Warn("could not retrieve data file, trying next mirror", "key", f.key, "error", err)
+		}
+	}
+	return version{}, errors.Join(errs...)
+}
+
+// filer is implemented by the data sources whose data files can be stored
+// to a data directory.
+type filer interface {
+	files() []dataFile
+}
+
+// Store downloads the data files of src to the directory dir, under the
+// names they are read from when dir is used as Dir, and returns the paths
+// of the written files.
+func Store(ctx context.Context, src DataSource, dir string) ([]string, error) {
+	fs, ok := src.(filer)
+	if !ok {
+		return nil, fmt.Errorf("data source %T can not be stored", src)
+	}
+
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, fmt.Errorf("could not create data directory: %w", err)
+	}
+
+	var fnames []string
+	for _, f := range fs.files() {
+		v, err := download(ctx, f.url, version{})
+		if err != nil {
+			return fnames, fmt.Errorf("could not retrieve %s: %w", f.name, err)
+		}
+
+		// write to a temporary file first, so a partial file is never read.
+		fname := filepath.Join(dir, f.name)
+		tmp := fname + ".tmp"
+		err = os.WriteFile(tmp, v.raw, 0644)
+		if err != nil {
+			return fnames, fmt.Errorf("could not write data file: %w", err)
+		}
+		err = os.Rename(tmp, fname)
+		if err != nil {
+			os.Remove(tmp)
+			return fnames, fmt.Errorf("could not rename data file: %w", err)
+		}
+		fnames = append(fnames, fname)
+	}
+	return fnames, nil
+}
@@ -0,0 +1,151 @@
+// Copyright 2020 The covid19 Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package data
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/sbinet/covid19/internal/logctx"
+)
+
+// version is a version of a data file, with the validators the data
+// source replied with.
+type version struct {
+	raw      []byte
+	etag     string // ETag of the version, if any
+	modified string // Last-Modified date of the version, if any
+
+	date time.Time // date of the latest data of the version, if known
+}
+
+// errNotModified is returned when the resource did not change since the
+// version given to download.
+var errNotModified = errors.New("not modified")
+
+// download retrieves the content of the resource at url, which may
+// also be a local file.
+// The validators of prev, the cached version of the resource if any, are
+// sent with the request so that an unchanged resource is not downloaded
+// again: errNotModified is returned then.
+// Network errors and server errors are retried with an exponential
+// backoff, starting at Backoff, until Attempts attempts or the download
+// timeout.
+func download(ctx context.Context, url string, prev version) (version, error) {
+	if fname, ok := localPath(url); ok {
+		raw, err := os.ReadFile(fname)
+		if err != nil {
+			return version{}, fmt.Errorf("could not read data file: %w", err)
+		}
+		return version{raw: raw}, nil
+	}
+
+	// the data may be shared with other requests through the cache, so
+	// only the values of ctx, such as its logger, are used.
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), DownloadTimeout)
+	defer cancel()
+
+	backoff := Backoff
+	for i := 1; ; i++ {
+		v, err := fetchURL(ctx, url, prev)
+		switch {
+		case err == nil, errors.Is(err, errNotModified):
+			return v, err
+		case i >= Attempts || ctx.Err() != nil || !retryable(err):
+			return version{}, fmt.Errorf("could not download %q (attempt %d of %d): %w", url, i, Attempts, err)
+		}
+		logctx.From(ctx).Warn(
+			"could not download data, retrying",
+			"url", url, "attempt", i, "backoff", backoff, "error", err,
+		)
+		select {
+		case <-ctx.Done():
+			return version{}, fmt.Errorf("could not download %q: %w", url, ctx.Err())
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// localPath returns the path of the file designated by src, when src
+// is a file:// URL or a path without a URL scheme.
+func localPath(src string) (string, bool) {
+	if fname, ok := strings.CutPrefix(src, "file://"); ok {
+		return fname, true
+	}
+	if !strings.Contains(src, "://") {
+		return src, true
+	}
+	return "", false
+}
+
+// fetchURL performs a single GET request of the resource at url,
+// conditional on the validators of prev.
+func fetchURL(ctx context.Context, url string, prev version) (version, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return version{}, fmt.Errorf("could not create request: %w", err)
+	}
+	if prev.etag != "" {
+		req.Header.Set("If-None-Match", prev.etag)
+	}
+	if prev.modified != "" {
+		req.Header.Set("If-Modified-Since", prev.modified)
+	}
+
+	resp, err := Client.Do(req)
+	if err != nil {
+		return version{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotModified && (prev.etag != "" || prev.modified != "") {
+		return prev, errNotModified
+	}
+
+	// error pages (missing file, rate limiting, ...) are not CSV data.
+	if resp.StatusCode != http.StatusOK {
+		return version{}, &statusError{code: resp.StatusCode}
+	}
+
+	raw, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return version{}, err
+	}
+	return version{
+		raw:      raw,
+		etag:     resp.Header.Get("ETag"),
+		modified: resp.Header.Get("Last-Modified"),
+	}, nil
+}
+
+// statusError is returned when the data source replies with an
+// unexpected HTTP status.
+type statusError struct {
+	code int
+}
+
+func (err *statusError) Error() string {
+	return fmt.Sprintf("data source returned status %d %s", err.code, http.StatusText(err.code))
+}
+
+// retryable returns whether the download failure err may be transient.
+// Server errors are worth retrying, while client errors are not.
+func retryable(err error) bool {
+	if errors.Is(err, errNotModified) {
+		return false
+	}
+	var status *statusError
+	if errors.As(err, &status) {
+		return status.code >= 500
+	}
+	return true
+}
@@ -0,0 +1,78 @@
+// Copyright 2020 The covid19 Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package data
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDownloadLocal(t *testing.T) {
+	const content = "Province/State,Country/Region,Lat,Long,4/1/20\n,Spain,40.4637,-3.7492,10\n"
+	fname := filepath.Join(t.TempDir(), "data.csv")
+	err := os.WriteFile(fname, []byte(content), 0644)
+	if err != nil {
+		t.Fatalf("could not write data file: %+v", err)
+	}
+
+	for _, src := range []string{fname, "file://" + fname} {
+		t.Run(src, func(t *testing.T) {
+			v, err := download(context.Background(), src, version{})
+			if err != nil {
+				t.Fatalf("could not read data file: %+v", err)
+			}
+			if got := string(v.raw); got != content {
+				t.Fatalf("invalid content:\ngot= %q\nwant=%q", got, content)
+			}
+		})
+	}
+
+	if _, ok := localPath("https://example.com/data.csv"); ok {
+		t.Fatalf("URL taken as a local path")
+	}
+}
+
+func TestDownloadTimeout(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		// stall past the timeout of the client.
+		select {
+		case <-req.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	defer srv.Close()
+
+	var (
+		client   = Client
+		attempts = Attempts
+		backoff  = Backoff
+	)
+	Client = &http.Client{Timeout: 50 * time.Millisecond}
+	Attempts = 2
+	Backoff = 10 * time.Millisecond
+	t.Cleanup(func() {
+		Client = client
+		Attempts = attempts
+		Backoff = backoff
+	})
+
+	start := time.Now()
+	_, err := download(context.Background(), srv.URL+"/data.csv", version{})
+	if err == nil {
+		t.Fatalf("expected a timeout error")
+	}
+	if d := time.Since(start); d > 2*time.Second {
+		t.Fatalf("download took %v to fail", d)
+	}
+	if got, want := err.Error(), "attempt 2 of 2"; !strings.Contains(got, want) {
+		t.Fatalf("invalid error: got=%q, want=%q", got, want)
+	}
+}
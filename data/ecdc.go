@@ -0,0 +1,152 @@
+// Copyright 2020 The covid19 Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package data
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ECDCSource is the URL of the data file of the European Centre for
+// Disease Prevention and Control.
+const ECDCSource = "https://opendata.ecdc.europa.eu/covid19/casedistribution/csv"
+
+// ECDC retrieves the daily cases and deaths reported by the European
+// Centre for Disease Prevention and Control, which are only available
+// for the countries.
+type ECDC struct {
+	URL string // URL, or local path, of the data file (ECDCSource if empty)
+}
+
+func (src ECDC) Fetch(ctx context.Context, title string, countries []string, opts Options) (Dataset, error) {
+	if opts.Level != "" && opts.Level != "country" {
+		return Dataset{}, fmt.Errorf("the ECDC data is not available at the %s level", opts.Level)
+	}
+	if _, err := ecdcColumn(title); err != nil {
+		return Dataset{}, err
+	}
+
+	f := src.file()
+	raw, err := fetchFile(ctx, f, opts)
+	if err != nil {
+		return Dataset{}, err
+	}
+	return f.parse(ctx, raw, title, countries, opts)
+}
+
+// ecdcColumn returns the column of the ECDC data holding the values of the
+// title dataset.
+func ecdcColumn(title string) (string, error) {
+	switch title {
+	case "confirmed":
+		return "cases", nil
+	case "deaths":
+		return "deaths", nil
+	default:
+		return "", fmt.Errorf("the ECDC data holds no %s series", title)
+	}
+}
+
+// ecdcLayout is the layout of the dates of the ECDC data.
+const ecdcLayout = "02/01/2006"
+
+// file returns the data file of the ECDC data.
+func (src ECDC) file() dataFile {
+	url := src.URL
+	if url == "" {
+		url = ECDCSource
+	}
+	return dataFile{
+		key:    "ecdc",
+		name:   "ecdc-casedistribution.csv",
+		url:    url,
+		titles: []string{"confirmed", "deaths"},
+		level:  "country",
+		latest: latestDate("dateRep", ecdcLayout),
+		parse:  src.parse,
+	}
+}
+
+// parse parses the title dataset of the requested countries from the
+// ECDC data file raw.
+func (src ECDC) parse(ctx context.Context, raw []byte, title string, countries []string, opts Options) (Dataset, error) {
+	col, err := ecdcColumn(title)
+	if err != nil {
+		return Dataset{}, err
+	}
+	recs, err := parseECDC(bytes.NewReader(raw), col, ecdcLayout)
+	if err != nil {
+		return Dataset{}, err
+	}
+	return recs.dataset(ctx, title, countries, opts)
+}
+
+func (src ECDC) files() []dataFile { return []dataFile{src.file()} }
+
+func (src ECDC) latest(ctx context.Context, title string, opts Options) (time.Time, error) {
+	return fileDate(ctx, src.file(), opts)
+}
+
+// parseECDC parses the daily values of the column col of the ECDC data
+// from r, and returns their cumulative sum.
+func parseECDC(r io.Reader, col, layout string) (records, error) {
+	raw := csv.NewReader(r)
+	hdr, err := raw.Read()
+	if err != nil {
+		return nil, fmt.Errorf("could not read CSV header: %w", err)
+	}
+	cols, err := csvColumns(hdr, "dateRep", "countriesAndTerritories", col)
+	if err != nil {
+		return nil, fmt.Errorf("invalid CSV header: %w", err)
+	}
+
+	type entry struct {
+		date time.Time
+		v    float64
+	}
+	daily := make(map[string][]entry)
+	for {
+		rec, err := raw.Read()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, fmt.Errorf("could not read CSV data: %w", err)
+		}
+		date, err := time.Parse(layout, rec[cols[0]])
+		if err != nil {
+			return nil, fmt.Errorf("could not parse date: %w", err)
+		}
+		// the ECDC names use underscores in place of spaces.
+		name := CanonicalName(strings.ReplaceAll(rec[cols[1]], "_", " "))
+		var v float64
+		if str := rec[cols[2]]; str != "" {
+			v, err = strconv.ParseFloat(str, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s value %q for %s: %w", col, str, name, err)
+			}
+		}
+		daily[name] = append(daily[name], entry{date, v})
+	}
+
+	recs := make(records, len(daily))
+	for name, es := range daily {
+		// the records are listed from the latest day.
+		sort.Slice(es, func(i, j int) bool { return es[i].date.Before(es[j].date) })
+		sum := 0.0
+		for _, e := range es {
+			sum += e.v
+			recs.add(name, e.date, sum)
+		}
+	}
+	return recs, nil
+}
@@ -0,0 +1,33 @@
+// Copyright 2020 The covid19 Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package data
+
+import "time"
+
+// Recorder records the events of the data retrieval, to export metrics.
+type Recorder interface {
+	// Lookup records a lookup of key in the data cache, with result one
+	// of "hit", "stale" or "miss".
+	Lookup(key, result string)
+
+	// Fetch records a download of the data stored under key, started
+	// at start.
+	Fetch(key string, start time.Time, err error)
+}
+
+// Metrics records the events of the data retrieval, if not nil.
+var Metrics Recorder
+
+func recordLookup(key, result string) {
+	if Metrics != nil {
+		Metrics.Lookup(key, result)
+	}
+}
+
+func recordFetch(key string, start time.Time, err error) {
+	if Metrics != nil {
+		Metrics.Fetch(key, start, err)
+	}
+}
@@ -0,0 +1,137 @@
+// Copyright 2020 The covid19 Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package data
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sbinet/covid19/internal/logctx"
+)
+
+// Override is a manual correction of the upstream data.
+type Override struct {
+	Source  string    // data source of the corrected value, as named by NewSource
+	Title   string    // dataset of the corrected value
+	Country string    // country of the corrected value
+	Date    time.Time // day of the corrected value
+	Value   float64   // value to use for that day
+}
+
+// LoadOverrides reads the data corrections stored in the CSV file fname.
+// Each record holds a data source name, a dataset title, a country, a date
+// (2006-01-02) and the value to use for that day.
+// Files without the source column hold corrections of the CSSE data.
+// A missing file yields no corrections.
+func LoadOverrides(fname string) ([]Override, error) {
+	f, err := os.Open(fname)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("could not open overrides file: %w", err)
+	}
+	defer f.Close()
+
+	return ParseOverrides(f)
+}
+
+// ParseOverrides parses the data corrections from r.
+func ParseOverrides(r io.Reader) ([]Override, error) {
+	raw := csv.NewReader(r)
+	raw.Comment = '#'
+
+	hdr, err := raw.Read()
+	if err != nil {
+		return nil, fmt.Errorf("could not read overrides header: %w", err)
+	}
+	source := "jhu" // source of the corrections of files without a source column.
+	switch v := strings.Join(hdr, ","); v {
+	case "source,title,country,date,value":
+		source = ""
+	case "title,country,date,value":
+	default:
+		return nil, fmt.Errorf("invalid overrides header %q (want %q)", v, "source,title,country,date,value")
+	}
+
+	var o []Override
+	for {
+		rec, err := raw.Read()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, fmt.Errorf("could not read overrides: %w", err)
+		}
+		src := source
+		if src == "" {
+			src, rec = rec[0], rec[1:]
+		}
+		if !titles[rec[0]] {
+			return nil, fmt.Errorf("invalid overrides title %q", rec[0])
+		}
+		date, err := time.Parse("2006-01-02", rec[2])
+		if err != nil {
+			return nil, fmt.Errorf("invalid overrides date %q: %w", rec[2], err)
+		}
+		v, err := strconv.ParseFloat(rec[3], 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid overrides value %q: %w", rec[3], err)
+		}
+		o = append(o, Override{
+			Source:  src,
+			Title:   rec[0],
+			Country: rec[1],
+			Date:    date,
+			Value:   v,
+		})
+	}
+	return o, nil
+}
+
+// cleanup applies the data corrections of the title dataset retrieved
+// from Backend.
+// The corrected day is located from the dates of the CSV header, so
+// corrections stay valid when the series are trimmed at their cutoff.
+func cleanup(ctx context.Context, title string, ds *Dataset) {
+	source := sourceName(Backend)
+	for _, ov := range Overrides {
+		if ov.Title != title || ov.Source != source {
+			continue
+		}
+		ys, ok := ds.Table[ov.Country]
+		if !ok {
+			continue
+		}
+		i := int(ds.Day(ov.Country, ov.Date))
+		if i < 0 || i >= len(ys) {
+			logctx.From(ctx).Debug(
+				"override out of range",
+				"title", title, "country", ov.Country, "date", ov.Date.Format("2006-01-02"),
+			)
+			continue
+		}
+		ys[i] = ov.Value
+	}
+}
+
+// Overrides holds the corrections applied to the upstream data.
+var Overrides []Override
+
+// titles holds the datasets retrieved from the data files, which
+// corrections can apply to.
+var titles = map[string]bool{
+	"confirmed": true,
+	"deaths":    true,
+	"recovered": true,
+}
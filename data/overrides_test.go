@@ -0,0 +1,83 @@
+// Copyright 2020 The covid19 Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package data
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseOverrides(t *testing.T) {
+	date := time.Date(2020, 3, 9, 0, 0, 0, 0, time.UTC)
+	for _, tc := range []struct {
+		name string
+		raw  string
+		want []Override
+	}{
+		{
+			name: "source",
+			raw:  "source,title,country,date,value\necdc,deaths,France,2020-03-09,30\n",
+			want: []Override{{Source: "ecdc", Title: "deaths", Country: "France", Date: date, Value: 30}},
+		},
+		{
+			// files without a source column hold corrections of the CSSE data.
+			name: "jhu",
+			raw:  "title,country,date,value\ndeaths,France,2020-03-09,30\n",
+			want: []Override{{Source: "jhu", Title: "deaths", Country: "France", Date: date, Value: 30}},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := ParseOverrides(strings.NewReader(tc.raw))
+			if err != nil {
+				t.Fatalf("could not parse overrides: %+v", err)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Fatalf("invalid overrides:\ngot= %v\nwant=%v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestCleanup(t *testing.T) {
+	var (
+		backend   = Backend
+		overrides = Overrides
+	)
+	t.Cleanup(func() {
+		Backend = backend
+		Overrides = overrides
+	})
+
+	start := time.Date(2020, 3, 1, 0, 0, 0, 0, time.UTC)
+	Overrides = []Override{
+		{Source: "jhu", Title: "deaths", Country: "France", Date: start.AddDate(0, 0, 1), Value: 30},
+	}
+
+	for _, tc := range []struct {
+		name string
+		src  DataSource
+		want []float64
+	}{
+		{"jhu", JHU{}, []float64{10, 30, 40}},
+		{"ecdc", ECDC{}, []float64{10, 20, 40}},
+		{"db", &DB{name: "jhu"}, []float64{10, 30, 40}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			Backend = tc.src
+			ds := Dataset{
+				Start:  start,
+				Table:  map[string][]float64{"France": {10, 20, 40}},
+				Cutoff: make(map[string]int),
+			}
+			cleanup(context.Background(), "deaths", &ds)
+			if got := ds.Table["France"]; !reflect.DeepEqual(got, tc.want) {
+				t.Fatalf("invalid series:\ngot= %v\nwant=%v", got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,142 @@
+// Copyright 2020 The covid19 Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package data
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// OWIDSource is the URL of the data file of Our World in Data.
+const OWIDSource = "https://covid.ourworldindata.org/data/owid-covid-data.csv"
+
+// OWID retrieves the cumulative cases and deaths compiled by Our World in
+// Data, which are only available for the countries.
+type OWID struct {
+	URL string // URL, or local path, of the data file (OWIDSource if empty)
+}
+
+func (src OWID) Fetch(ctx context.Context, title string, countries []string, opts Options) (Dataset, error) {
+	if opts.Level != "" && opts.Level != "country" {
+		return Dataset{}, fmt.Errorf("the OWID data is not available at the %s level", opts.Level)
+	}
+	if _, err := owidColumn(title); err != nil {
+		return Dataset{}, err
+	}
+
+	f := src.file()
+	raw, err := fetchFile(ctx, f, opts)
+	if err != nil {
+		return Dataset{}, err
+	}
+	return f.parse(ctx, raw, title, countries, opts)
+}
+
+// owidColumn returns the column of the OWID data holding the values of the
+// title dataset.
+func owidColumn(title string) (string, error) {
+	switch title {
+	case "confirmed":
+		return "total_cases", nil
+	case "deaths":
+		return "total_deaths", nil
+	default:
+		return "", fmt.Errorf("the OWID data holds no %s series", title)
+	}
+}
+
+// owidLayout is the layout of the dates of the OWID data.
+const owidLayout = "2006-01-02"
+
+// file returns the data file of the OWID data.
+func (src OWID) file() dataFile {
+	url := src.URL
+	if url == "" {
+		url = OWIDSource
+	}
+	return dataFile{
+		key:    "owid",
+		name:   "owid-covid-data.csv",
+		url:    url,
+		titles: []string{"confirmed", "deaths"},
+		level:  "country",
+		latest: latestDate("date", owidLayout),
+		parse:  src.parse,
+	}
+}
+
+// parse parses the title dataset of the requested countries from the
+// OWID data file raw.
+func (src OWID) parse(ctx context.Context, raw []byte, title string, countries []string, opts Options) (Dataset, error) {
+	col, err := owidColumn(title)
+	if err != nil {
+		return Dataset{}, err
+	}
+	recs, err := parseOWID(bytes.NewReader(raw), col, owidLayout)
+	if err != nil {
+		return Dataset{}, err
+	}
+	return recs.dataset(ctx, title, countries, opts)
+}
+
+func (src OWID) files() []dataFile { return []dataFile{src.file()} }
+
+func (src OWID) latest(ctx context.Context, title string, opts Options) (time.Time, error) {
+	return fileDate(ctx, src.file(), opts)
+}
+
+// parseOWID parses the cumulative values of the column col of the OWID
+// data from r.
+func parseOWID(r io.Reader, col, layout string) (records, error) {
+	raw := csv.NewReader(r)
+	hdr, err := raw.Read()
+	if err != nil {
+		return nil, fmt.Errorf("could not read CSV header: %w", err)
+	}
+	cols, err := csvColumns(hdr, "iso_code", "location", "date", col)
+	if err != nil {
+		return nil, fmt.Errorf("invalid CSV header: %w", err)
+	}
+
+	recs := make(records)
+	for {
+		rec, err := raw.Read()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, fmt.Errorf("could not read CSV data: %w", err)
+		}
+		// continents, income groups and the world are not countries.
+		if strings.HasPrefix(rec[cols[0]], "OWID_") {
+			continue
+		}
+		name := CanonicalName(rec[cols[1]])
+		date, err := time.Parse(layout, rec[cols[2]])
+		if err != nil {
+			return nil, fmt.Errorf("could not parse date: %w", err)
+		}
+		str := rec[cols[3]]
+		if str == "" {
+			// missing days carry the previous value forward.
+			if _, ok := recs[name]; !ok {
+				recs.add(name, date, 0)
+			}
+			continue
+		}
+		v, err := strconv.ParseFloat(str, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s value %q for %s: %w", col, str, name, err)
+		}
+		recs.add(name, date, v)
+	}
+	return recs, nil
+}
@@ -0,0 +1,81 @@
+// Copyright 2020 The covid19 Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package data
+
+import (
+	"context"
+
+	"github.com/sbinet/covid19/internal/logctx"
+)
+
+// PerCapita normalizes the series of the requested countries to the
+// number of people given by base.
+// PerCapita returns the countries for which the population is known.
+func PerCapita(ctx context.Context, ds *Dataset, countries []string, base float64) []string {
+	var o []string
+	for _, name := range countries {
+		pop, ok := populationDB[name]
+		if !ok || pop <= 0 {
+			logctx.From(ctx).Warn("no population data, skipping", "country", name)
+			continue
+		}
+		ys := ds.Table[name]
+		for i := range ys {
+			ys[i] *= base / pop
+		}
+		o = append(o, name)
+	}
+	return o
+}
+
+var (
+	// populationDB holds the 2020 population of countries,
+	// as estimated by the UN World Population Prospects.
+	populationDB = map[string]float64{
+		"Argentina":      45195774,
+		"Australia":      25499884,
+		"Austria":        9006398,
+		"Belgium":        11589623,
+		"Brazil":         212559417,
+		"Canada":         37742154,
+		"Chile":          19116201,
+		"China":          1439323776,
+		"Colombia":       50882891,
+		"Czechia":        10708981,
+		"Denmark":        5792202,
+		"Egypt":          102334404,
+		"Finland":        5540720,
+		"France":         65273511,
+		"Germany":        83783942,
+		"Greece":         10423054,
+		"Iceland":        341243,
+		"India":          1380004385,
+		"Indonesia":      273523615,
+		"Iran":           83992949,
+		"Ireland":        4937786,
+		"Israel":         8655535,
+		"Italy":          60461826,
+		"Japan":          126476461,
+		"Korea, South":   51269185,
+		"Luxembourg":     625978,
+		"Mexico":         128932753,
+		"Netherlands":    17134872,
+		"Norway":         5421241,
+		"Pakistan":       220892340,
+		"Peru":           32971854,
+		"Philippines":    109581078,
+		"Poland":         37846611,
+		"Portugal":       10196709,
+		"Russia":         145934462,
+		"Saudi Arabia":   34813871,
+		"South Africa":   59308690,
+		"Spain":          46754778,
+		"Sweden":         10099265,
+		"Switzerland":    8654622,
+		"Turkey":         84339067,
+		"US":             331002651,
+		"United Kingdom": 67886011,
+	}
+)
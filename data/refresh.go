@@ -0,0 +1,91 @@
+// Copyright 2020 The covid19 Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package data
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"time"
+
+	"github.com/sbinet/covid19/internal/logctx"
+)
+
+// Refresh retrieves a new version of the data files of src every interval,
+// starting immediately, until ctx is done.
+//
+// Each new version is validated before replacing the cached one, so the
+// requests are served from the cache without waiting for a download, and
+// an invalid version keeps the previous one in use. Once Refresh is
+// called, the cached data files are not refreshed on expiry anymore.
+func Refresh(ctx context.Context, src DataSource, interval time.Duration) error {
+	fs, ok := src.(filer)
+	if !ok {
+		return fmt.Errorf("data source %T can not be refreshed", src)
+	}
+	dataCache.schedule()
+
+	tick := time.NewTicker(interval)
+	defer tick.Stop()
+	for {
+		for _, f := range fs.files() {
+			err := refreshFile(ctx, f)
+			if err != nil {
+				logctx.From(ctx).Warn("could not refresh data", "key", f.key, "error", err)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-tick.C:
+		}
+	}
+}
+
+// refreshFile downloads a new version of the data file f, and replaces
+// the cached version with it once validated.
+func refreshFile(ctx context.Context, f dataFile) error {
+	prev, cached := dataCache.lookup(f.key)
+	v, err := f.download(ctx, prev)
+	if errors.Is(err, errNotModified) {
+		logctx.From(ctx).Debug("data not modified", "key", f.key)
+		dataCache.set(f.key, prev)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	date, err := f.latest(v.raw)
+	if err != nil {
+		return fmt.Errorf("invalid data file: %w", err)
+	}
+	if cached {
+		old, err := f.latest(prev.raw)
+		if err == nil && date.Before(old) {
+			return fmt.Errorf(
+				"data until %s is older than the cached data until %s",
+				date.Format("2006-01-02"), old.Format("2006-01-02"),
+			)
+		}
+	}
+
+	// the whole file is parsed, so the warnings about its values would
+	// be logged at each refresh.
+	quiet := logctx.With(ctx, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	for _, title := range f.titles {
+		_, err := f.parse(quiet, v.raw, title, nil, Options{Level: f.level})
+		if err != nil {
+			return fmt.Errorf("invalid %s data: %w", title, err)
+		}
+	}
+
+	dataCache.set(f.key, v)
+	logctx.From(ctx).Info("data refreshed", "key", f.key, "date", date.Format("2006-01-02"))
+	return nil
+}
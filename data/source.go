@@ -0,0 +1,359 @@
+// Copyright 2020 The covid19 Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package data
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sbinet/covid19/internal/logctx"
+)
+
+// DataSource retrieves the cumulative time series published by an
+// upstream provider.
+type DataSource interface {
+	// Fetch retrieves the untrimmed title dataset ("confirmed", "deaths"
+	// or "recovered") of the requested countries.
+	// All the countries present in the data are collected when countries
+	// is nil.
+	Fetch(ctx context.Context, title string, countries []string, opts Options) (Dataset, error)
+}
+
+// Backend is the data source used by Fetch.
+var Backend DataSource = JHU{}
+
+// dater is implemented by the data sources that can report the date of
+// the latest data of a dataset without parsing it.
+type dater interface {
+	// latest returns the date of the latest data of the title dataset
+	// ("confirmed", "deaths" or "recovered").
+	latest(ctx context.Context, title string, opts Options) (time.Time, error)
+}
+
+// NewSource returns the data source of the given name, "jhu", "ecdc" or
+// "owid", which retrieves its data from url, or from the default location
+// of the source when url is empty.
+func NewSource(name, url string) (DataSource, error) {
+	switch name {
+	case "jhu":
+		return JHU{URL: url}, nil
+	case "ecdc":
+		return ECDC{URL: url}, nil
+	case "owid":
+		return OWID{URL: url}, nil
+	default:
+		return nil, fmt.Errorf("invalid data source %q", name)
+	}
+}
+
+// sourceName returns the name of the data source src, as given to
+// NewSource, or the name of the data source it persists.
+func sourceName(src DataSource) string {
+	switch src := src.(type) {
+	case JHU:
+		return "jhu"
+	case ECDC:
+		return "ecdc"
+	case OWID:
+		return "owid"
+	case *DB:
+		return src.name
+	}
+	return ""
+}
+
+// JHU retrieves the time series of the CSSE at Johns Hopkins University,
+// which are available for the countries, their provinces, and the US
+// states and counties.
+type JHU struct {
+	URL string // base URL, or local directory, of the data files (Source if empty)
+}
+
+func (src JHU) Fetch(ctx context.Context, title string, countries []string, opts Options) (Dataset, error) {
+	// the US files only hold the confirmed cases and the deaths.
+	if title == "recovered" && jhuRegion(opts.Level) == "US" {
+		return Dataset{}, fmt.Errorf("recovered cases are not available at the %s level", opts.Level)
+	}
+
+	f := src.file(title, jhuRegion(opts.Level))
+	raw, err := fetchFile(ctx, f, opts)
+	if err != nil {
+		return Dataset{}, err
+	}
+	return f.parse(ctx, raw, title, countries, opts)
+}
+
+func (src JHU) latest(ctx context.Context, title string, opts Options) (time.Time, error) {
+	return fileDate(ctx, src.file(title, jhuRegion(opts.Level)), opts)
+}
+
+// jhuRegion returns the region of the data files holding the series of
+// level: US states and counties are only available from the US-specific
+// files, while the provinces are listed by the global ones.
+func jhuRegion(level string) string {
+	if level == "state" || level == "county" {
+		return "US"
+	}
+	return "global"
+}
+
+// file returns the data file of the title dataset of region, "global"
+// or "US".
+func (src JHU) file(title, region string) dataFile {
+	base := src.URL
+	if base == "" {
+		base = Source
+	}
+	level := "country"
+	if region == "US" {
+		level = "state"
+	}
+	name := fmt.Sprintf("time_series_covid19_%s_%s.csv", title, region)
+	return dataFile{
+		key:    title + "_" + region,
+		name:   name,
+		url:    strings.TrimRight(base, "/") + "/" + name,
+		titles: []string{title},
+		level:  level,
+		latest: csvDate,
+		parse: func(ctx context.Context, raw []byte, title string, countries []string, opts Options) (Dataset, error) {
+			return ParseCSV(ctx, bytes.NewReader(raw), title, 0, countries, opts)
+		},
+	}
+}
+
+func (src JHU) files() []dataFile {
+	return []dataFile{
+		src.file("confirmed", "global"),
+		src.file("deaths", "global"),
+		src.file("recovered", "global"),
+		src.file("confirmed", "US"),
+		src.file("deaths", "US"),
+	}
+}
+
+// fetchFile retrieves the data file f through the data cache, or its
+// archived version as of opts.AsOf, when set.
+func fetchFile(ctx context.Context, f dataFile, opts Options) ([]byte, error) {
+	v, err := fileVersion(ctx, f, opts)
+	if err != nil {
+		return nil, err
+	}
+	return v.raw, nil
+}
+
+// fileVersion retrieves the version of the data file f held by the data
+// cache, or its archived version as of opts.AsOf, when set.
+func fileVersion(ctx context.Context, f dataFile, opts Options) (version, error) {
+	if !opts.AsOf.IsZero() {
+		raw, err := archived(f.key, opts.AsOf)
+		if err != nil {
+			return version{}, err
+		}
+		return version{raw: raw}, nil
+	}
+
+	v, err := dataCache.get(ctx, f.key, func(prev version) (version, error) {
+		return f.download(ctx, prev)
+	})
+	if err != nil {
+		return version{}, fmt.Errorf("could not retrieve data file: %w", err)
+	}
+	return v, nil
+}
+
+// fileDate returns the date of the latest data of the data file f, until
+// opts.Until, as of opts.AsOf when set.
+// The date of the cached version of the file is used when known, instead
+// of reading it from the file.
+func fileDate(ctx context.Context, f dataFile, opts Options) (time.Time, error) {
+	v, err := fileVersion(ctx, f, opts)
+	if err != nil {
+		return time.Time{}, err
+	}
+	date := v.date
+	if date.IsZero() {
+		date, err = f.latest(v.raw)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid data file: %w", err)
+		}
+	}
+	if !opts.Until.IsZero() && date.After(opts.Until) {
+		// the days after until are dropped.
+		date = opts.Until
+	}
+	return date, nil
+}
+
+// records holds the cumulative values of a dataset read from a file with
+// one record per country and day.
+type records map[string]map[time.Time]float64
+
+func (recs records) add(name string, date time.Time, v float64) {
+	vs, ok := recs[name]
+	if !ok {
+		vs = make(map[time.Time]float64)
+		recs[name] = vs
+	}
+	vs[date] = v
+}
+
+// dataset returns the untrimmed series of the requested countries, from
+// the first day to the last day of the records, or until opts.Until.
+// Days missing from a series carry its latest finite value forward.
+func (recs records) dataset(ctx context.Context, title string, countries []string, opts Options) (Dataset, error) {
+	var start, end time.Time
+	for _, vs := range recs {
+		for date := range vs {
+			if !opts.Until.IsZero() && date.After(opts.Until) {
+				continue
+			}
+			if start.IsZero() || date.Before(start) {
+				start = date
+			}
+			if end.IsZero() || date.After(end) {
+				end = date
+			}
+		}
+	}
+	if start.IsZero() {
+		if !opts.Until.IsZero() {
+			return Dataset{}, fmt.Errorf("no data until %s", opts.Until.Format("2006-01-02"))
+		}
+		return Dataset{}, fmt.Errorf("no data")
+	}
+
+	if countries == nil {
+		for name := range recs {
+			countries = append(countries, name)
+		}
+		sort.Strings(countries)
+	}
+
+	var unknown []string
+	for _, name := range countries {
+		if _, ok := recs[name]; !ok {
+			unknown = append(unknown, name)
+		}
+	}
+	if len(unknown) > 0 {
+		known := make([]string, 0, len(recs))
+		for name := range recs {
+			known = append(known, name)
+		}
+		if opts.Level == "" || opts.Level == "country" {
+			known = append(known, Aggregates()...)
+		}
+		return Dataset{}, unknownCountries(unknown, known)
+	}
+
+	sz := int(end.Sub(start).Hours()/24) + 1
+	ds := Dataset{
+		Date:   end,
+		Start:  start,
+		Table:  make(map[string][]float64, len(countries)),
+		Cutoff: make(map[string]int, len(countries)),
+	}
+	for _, name := range countries {
+		var (
+			vs   = recs[name]
+			ys   = make([]float64, sz)
+			last float64 // latest finite value, carried over missing days
+		)
+		for i := range ys {
+			date := start.AddDate(0, 0, i)
+			v, ok := vs[date]
+			if !ok {
+				ys[i] = last
+				continue
+			}
+			if v < 0 {
+				logctx.From(ctx).Warn(
+					"negative value",
+					"title", title, "country", name, "date", date.Format("2006-01-02"),
+					"value", v, "policy", opts.Negatives,
+				)
+				switch opts.Negatives {
+				case "keep":
+				case "drop":
+					v = math.NaN()
+				default:
+					v = 0
+				}
+			}
+			ys[i] = v
+			if !math.IsNaN(v) {
+				last = v
+			}
+		}
+		ds.Table[name] = ys
+	}
+	return ds, nil
+}
+
+// csvColumns returns the index of each of the named columns of the CSV
+// header hdr.
+func csvColumns(hdr []string, names ...string) ([]int, error) {
+	cols := make([]int, len(names))
+	for i, name := range names {
+		cols[i] = -1
+		for j, v := range hdr {
+			if strings.TrimSpace(v) == name {
+				cols[i] = j
+				break
+			}
+		}
+		if cols[i] < 0 {
+			return nil, fmt.Errorf("missing %s column", name)
+		}
+	}
+	return cols, nil
+}
+
+// latestDate returns a function returning the date of the latest data
+// of a CSV file, the largest value of its column name, in the given
+// layout.
+func latestDate(name, layout string) func(raw []byte) (time.Time, error) {
+	return func(raw []byte) (time.Time, error) {
+		r := csv.NewReader(bytes.NewReader(raw))
+		hdr, err := r.Read()
+		if err != nil {
+			return time.Time{}, fmt.Errorf("could not read CSV header: %w", err)
+		}
+		cols, err := csvColumns(hdr, name)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid CSV header: %w", err)
+		}
+
+		var latest time.Time
+		for {
+			rec, err := r.Read()
+			if err != nil {
+				if err == io.EOF {
+					break
+				}
+				return time.Time{}, fmt.Errorf("could not read CSV data: %w", err)
+			}
+			date, err := time.Parse(layout, rec[cols[0]])
+			if err != nil {
+				return time.Time{}, fmt.Errorf("could not parse date: %w", err)
+			}
+			if date.After(latest) {
+				latest = date
+			}
+		}
+		if latest.IsZero() {
+			return time.Time{}, fmt.Errorf("no data")
+		}
+		return latest, nil
+	}
+}
@@ -0,0 +1,79 @@
+// Copyright 2020 The covid19 Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !nosqlite
+
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+func TestDBSQLite(t *testing.T) {
+	var (
+		ctx   = context.Background()
+		fname = filepath.Join(t.TempDir(), "covid19.db")
+		src   = &memSource{
+			ds: Dataset{
+				Start: time.Date(2020, 3, 1, 0, 0, 0, 0, time.UTC),
+				Table: map[string][]float64{
+					"France": {1, 2, 3},
+					"Italy":  {0, 5, 10},
+				},
+			},
+		}
+		want = map[string][]float64{
+			"France": {1, 2, 3},
+			"Italy":  {0, 5, 10},
+		}
+	)
+
+	open := func(t *testing.T) (*DB, *sql.DB) {
+		t.Helper()
+		sqldb, err := sql.Open("sqlite", fname)
+		if err != nil {
+			t.Fatalf("could not open database: %+v", err)
+		}
+		db, err := NewDB(ctx, sqldb, "jhu", src)
+		if err != nil {
+			sqldb.Close()
+			t.Fatalf("could not create database: %+v", err)
+		}
+		return db, sqldb
+	}
+
+	db, sqldb := open(t)
+	ds, err := db.Fetch(ctx, "confirmed", []string{"France", "Italy"}, Options{})
+	if err != nil {
+		t.Fatalf("could not fetch series: %+v", err)
+	}
+	if !reflect.DeepEqual(ds.Table, want) {
+		t.Fatalf("invalid series:\ngot= %v\nwant=%v", ds.Table, want)
+	}
+	err = sqldb.Close()
+	if err != nil {
+		t.Fatalf("could not close database: %+v", err)
+	}
+
+	// the series are served from the database file once reopened.
+	src.err = errors.New("data source unreachable")
+	db, sqldb = open(t)
+	defer sqldb.Close()
+
+	ds, err = db.Fetch(ctx, "confirmed", []string{"France", "Italy"}, Options{})
+	if err != nil {
+		t.Fatalf("could not fetch stored series: %+v", err)
+	}
+	if !reflect.DeepEqual(ds.Table, want) {
+		t.Fatalf("invalid stored series:\ngot= %v\nwant=%v", ds.Table, want)
+	}
+}
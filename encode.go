@@ -0,0 +1,328 @@
+// Copyright 2020 The covid19 Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"go-hep.org/x/hep/hplot"
+	"gonum.org/v1/plot/vg"
+	"gonum.org/v1/plot/vg/draw"
+	"gonum.org/v1/plot/vg/vgimg"
+	"gonum.org/v1/plot/vg/vgpdf"
+	"gonum.org/v1/plot/vg/vgsvg"
+)
+
+// figure is a drawing together with its dimensions.
+type figure struct {
+	drawer interface{ Draw(draw.Canvas) }
+	width  vg.Length
+	height vg.Length
+	dpi    int // resolution of raster images, or 0 for the default
+}
+
+// resize returns fig rasterized to w×h pixels, at dpi dots per inch.
+// When only one of w or h is given, the aspect ratio of fig is kept.
+// Without an explicit resolution, the resolution is scaled with the
+// requested size, so the text keeps its size relative to the drawing.
+// Otherwise the drawing is laid out for the w×h/dpi physical size, so
+// the text keeps its printed size.
+func (fig figure) resize(w, h, dpi int) figure {
+	// the dimension derived from the aspect ratio is bounded as well.
+	aspect := float64(fig.width / fig.height)
+	switch {
+	case w <= 0 && float64(h)*aspect > maxImageSize:
+		w, h = maxImageSize, 0
+	case h <= 0 && float64(w)/aspect > maxImageSize:
+		w, h = 0, maxImageSize
+	}
+
+	if dpi > 0 {
+		return fig.resample(w, h, dpi)
+	}
+
+	var px, length float64 // reference dimension, in pixels and in inches
+	switch {
+	case w > 0:
+		px, length = float64(w), float64(fig.width/vg.Inch)
+	case h > 0:
+		px, length = float64(h), float64(fig.height/vg.Inch)
+	default:
+		return fig
+	}
+	fig.dpi = int(math.Max(1, math.Round(px/length)))
+
+	var (
+		res    = vg.Length(fig.dpi)
+		scale  = vg.Length(px / float64(fig.dpi) / length)
+		width  = fig.width * scale
+		height = fig.height * scale
+	)
+	if w > 0 {
+		width = vg.Length(w) / res * vg.Inch
+	}
+	if h > 0 {
+		height = vg.Length(h) / res * vg.Inch
+	}
+	fig.width, fig.height = width, height
+	return fig
+}
+
+// resample returns fig rasterized at dpi dots per inch, to w×h pixels
+// when given, or at its own physical size otherwise.
+func (fig figure) resample(w, h, dpi int) figure {
+	var (
+		aspect = fig.width / fig.height
+		res    = vg.Length(dpi)
+	)
+	switch {
+	case w > 0 && h > 0:
+		fig.width = vg.Length(w) / res * vg.Inch
+		fig.height = vg.Length(h) / res * vg.Inch
+	case w > 0:
+		fig.width = vg.Length(w) / res * vg.Inch
+		fig.height = fig.width / aspect
+	case h > 0:
+		fig.height = vg.Length(h) / res * vg.Inch
+		fig.width = fig.height * aspect
+	default:
+		// bound the size of the allocated canvas.
+		size := float64(max(fig.width, fig.height) / vg.Inch)
+		dpi = min(dpi, int(maxImageSize/size))
+	}
+	fig.dpi = dpi
+	return fig
+}
+
+// drawFunc adapts a function into a drawer for a figure.
+type drawFunc func(c draw.Canvas)
+
+func (f drawFunc) Draw(c draw.Canvas) { f(c) }
+
+// canvas is a vector graphics canvas that can be encoded to a writer.
+type canvas interface {
+	vg.CanvasSizer
+	io.WriterTo
+}
+
+// encoders holds the supported output formats of the image endpoints.
+var encoders = map[string]encoder{
+	"png": {
+		ctype: "image/png",
+		canvas: func(w, h vg.Length, dpi int) canvas {
+			if dpi <= 0 {
+				dpi = vgimg.DefaultDPI
+			}
+			return vgimg.PngCanvas{Canvas: vgimg.NewWith(vgimg.UseWH(w, h), vgimg.UseDPI(dpi))}
+		},
+	},
+	"pdf": {
+		ctype: "application/pdf",
+		canvas: func(w, h vg.Length, dpi int) canvas {
+			return vgpdf.New(w, h)
+		},
+	},
+	"svg": {
+		ctype: "image/svg+xml",
+		canvas: func(w, h vg.Length, dpi int) canvas {
+			return vgsvg.New(w, h)
+		},
+	},
+}
+
+type encoder struct {
+	ctype  string                               // content type of the encoded image
+	canvas func(w, h vg.Length, dpi int) canvas // dpi is ignored by vector formats
+}
+
+// errFormat is returned for an unsupported output format.
+var errFormat = errors.New("invalid format")
+
+// parseFormat validates the requested output format.
+// When no format is requested, it is negotiated from the Accept header.
+func parseFormat(v, accept string) (string, error) {
+	if v == "" {
+		return negotiateFormat(accept), nil
+	}
+	if _, ok := encoders[v]; !ok {
+		return "", fmt.Errorf(
+			"%w %q (supported formats: %s)",
+			errFormat, v, strings.Join(formats(), ", "),
+		)
+	}
+	return v, nil
+}
+
+// negotiateFormat returns the output format preferred by a client sending
+// the accept header. PNG is used unless another format is explicitly
+// given a higher preference, since browsers accept any image type.
+func negotiateFormat(accept string) string {
+	var (
+		format = "png"
+		pngQ   = -1.0 // preference for PNG, or -1 when not acceptable
+		bestQ  = -1.0 // preference for format
+	)
+	for _, rng := range strings.Split(accept, ",") {
+		ctype, params, _ := strings.Cut(rng, ";")
+		ctype = strings.TrimSpace(ctype)
+		q := 1.0
+		for _, param := range strings.Split(params, ";") {
+			k, v, _ := strings.Cut(strings.TrimSpace(param), "=")
+			if k != "q" {
+				continue
+			}
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				q = f
+			}
+		}
+		if q <= 0 {
+			continue
+		}
+		switch ctype {
+		case "image/png", "image/*", "*/*":
+			pngQ = math.Max(pngQ, q)
+			continue
+		}
+		for name, enc := range encoders {
+			if enc.ctype == ctype && q > bestQ {
+				format, bestQ = name, q
+			}
+		}
+	}
+	if pngQ >= bestQ {
+		return "png"
+	}
+	return format
+}
+
+// formats returns the sorted list of supported output formats.
+func formats() []string {
+	names := make([]string, 0, len(encoders))
+	for name := range encoders {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// render draws fig and encodes it with the requested output format
+// and dimensions.
+func render(fig figure, opts Options) ([]byte, error) {
+	format := opts.Format
+	fig = fig.resize(opts.Width, opts.Height, opts.DPI)
+	cnv := encoders[format].canvas(fig.width, fig.height, fig.dpi)
+	fig.drawer.Draw(draw.New(cnv))
+
+	var buf bytes.Buffer
+	_, err := cnv.WriteTo(&buf)
+	if err != nil {
+		return nil, fmt.Errorf("could not encode %s image: %w", format, err)
+	}
+	return buf.Bytes(), nil
+}
+
+// encodeImage writes fig to w, using the requested output format.
+func encodeImage(w http.ResponseWriter, fig figure, opts Options) error {
+	raw, err := render(fig, opts)
+	if err != nil {
+		return err
+	}
+	return writeImage(w, raw, opts)
+}
+
+// writeImage writes the image raw, encoded with the requested output
+// format, to w.
+func writeImage(w http.ResponseWriter, raw []byte, opts Options) error {
+	w.Header().Set("Content-Type", encoders[opts.Format].ctype)
+	_, err := w.Write(raw)
+	return err
+}
+
+// optionsError replies to an image request with the error err of the
+// parsing of its options.
+// An unsupported output format is reported as plain text with a 400
+// status instead of an image, as the client did not ask for PNG images.
+func optionsError(w http.ResponseWriter, err error) {
+	if errors.Is(err, errFormat) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	imageError(w, err.Error())
+}
+
+// imageError replies to an image request with a PNG image displaying
+// the error message msg, so the reason of the failure shows up in the
+// page embedding the plot instead of a broken image.
+// The image is served with a 200 status and is not cached.
+func imageError(w http.ResponseWriter, msg string) {
+	const (
+		width  = 8 * vg.Inch
+		margin = 0.2 * vg.Inch
+		cols   = 90 // maximum number of characters of a line of text
+	)
+	var (
+		th    = themes["light"]
+		font  = hplot.DefaultStyle.Fonts.Legend
+		lines = wrap("error: "+msg, cols)
+		lineH = 1.5 * font.Size
+	)
+	fig := figure{
+		drawer: drawFunc(func(c draw.Canvas) {
+			th.fill(c)
+			sty := draw.TextStyle{Color: th.Foreground, Font: font, YAlign: draw.YTop}
+			for i, line := range lines {
+				c.FillText(sty, vg.Point{
+					X: c.Min.X + margin,
+					Y: c.Max.Y - margin - vg.Length(i)*lineH,
+				}, line)
+			}
+		}),
+		width:  width,
+		height: 2*margin + vg.Length(len(lines))*lineH,
+	}
+
+	raw, err := render(fig, Options{Format: "png"})
+	if err != nil {
+		slog.Error("could not render error image", "error", err)
+		http.Error(w, msg, http.StatusInternalServerError)
+		return
+	}
+	// the caching headers of the plot do not apply to the error.
+	w.Header().Del("ETag")
+	w.Header().Del("Last-Modified")
+	w.Header().Set("Content-Type", encoders["png"].ctype)
+	w.Header().Set("Cache-Control", "no-store")
+	_, _ = w.Write(raw)
+}
+
+// wrap splits txt into lines of at most n characters, breaking lines
+// between words. Words longer than n are kept on their own line.
+func wrap(txt string, n int) []string {
+	var (
+		lines []string
+		line  string
+	)
+	for _, word := range strings.Fields(txt) {
+		switch {
+		case line == "":
+			line = word
+		case len(line)+1+len(word) > n:
+			lines = append(lines, line)
+			line = word
+		default:
+			line += " " + word
+		}
+	}
+	return append(lines, line)
+}
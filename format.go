@@ -0,0 +1,53 @@
+// Copyright 2020 The covid19 Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"math"
+	"strconv"
+)
+
+// Default number of decimal places used to display the various kinds
+// of values. All the outputs (legends, JSON, CSV) should go through
+// Options.round or Options.format so they agree with each other.
+const (
+	precCount   = 0 // raw counts of cases or deaths
+	precPercent = 1 // percentages and ratios expressed as percentages
+	precRate    = 2 // per-capita rates and other ratios
+	precDays    = 1 // durations in days, such as doubling times
+)
+
+// titlePrec returns the default number of decimal places of the values of
+// the title dataset: the case fatality ratio is a percentage, while the
+// other datasets are counts.
+func titlePrec(title string) int {
+	if title == "cfr" {
+		return precPercent
+	}
+	return precCount
+}
+
+// prec returns the number of decimal places to use for a value that
+// would be displayed with def decimal places by default.
+func (opts Options) prec(def int) int {
+	if opts.Precision >= 0 {
+		return opts.Precision
+	}
+	return def
+}
+
+// round rounds v to the precision selected for values of that kind.
+func (opts Options) round(v float64, def int) float64 {
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return v
+	}
+	p := math.Pow(10, float64(opts.prec(def)))
+	return math.Round(v*p) / p
+}
+
+// format formats v with the precision selected for values of that kind.
+func (opts Options) format(v float64, def int) string {
+	return strconv.FormatFloat(opts.round(v, def), 'f', opts.prec(def), 64)
+}
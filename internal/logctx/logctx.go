@@ -0,0 +1,28 @@
+// Copyright 2020 The covid19 Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package logctx carries the logger of a request in its context.
+package logctx // import "github.com/sbinet/covid19/internal/logctx"
+
+import (
+	"context"
+	"log/slog"
+)
+
+// loggerKey is the context key of the logger of a request.
+type loggerKey struct{}
+
+// With returns a copy of ctx carrying the logger l.
+func With(ctx context.Context, l *slog.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, l)
+}
+
+// From returns the logger of the request of ctx, which tags each
+// record with the request ID, or the default logger.
+func From(ctx context.Context) *slog.Logger {
+	if l, ok := ctx.Value(loggerKey{}).(*slog.Logger); ok {
+		return l
+	}
+	return slog.Default()
+}
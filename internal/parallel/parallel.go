@@ -0,0 +1,33 @@
+// Copyright 2020 The covid19 Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package parallel runs functions concurrently.
+package parallel // import "github.com/sbinet/covid19/internal/parallel"
+
+import "sync"
+
+// Do runs the fs functions concurrently and waits for them to complete.
+// It returns the error of the first function that failed, in the order
+// of fs, so errors are reported deterministically.
+func Do(fs ...func() error) error {
+	var (
+		wg   sync.WaitGroup
+		errs = make([]error, len(fs))
+	)
+	for i, f := range fs {
+		wg.Add(1)
+		go func(i int, f func() error) {
+			defer wg.Done()
+			errs[i] = f()
+		}(i, f)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+// Copyright 2020 The covid19 Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package singleflight deduplicates concurrent calls of a function.
+package singleflight // import "github.com/sbinet/covid19/internal/singleflight"
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// Group runs functions keyed by a string, sharing the result of a call
+// with the callers asking for the same key while it is in flight.
+// The zero value is ready to use.
+type Group[V any] struct {
+	mu    sync.Mutex
+	calls map[string]*call[V]
+}
+
+type call[V any] struct {
+	done    chan struct{}
+	v       V
+	err     error
+	waiters int                // number of callers waiting for the call
+	cancel  context.CancelFunc // cancels the context of the call
+}
+
+// Do runs f and returns its results, unless a call for key is already
+// in flight, in which case it waits for that call to complete and
+// returns its results instead.
+//
+// f is given a context carrying the values of ctx, which is only
+// cancelled once all the callers waiting for it have given up, their
+// own context being done. Do then returns the error of that context.
+func (g *Group[V]) Do(ctx context.Context, key string, f func(ctx context.Context) (V, error)) (V, error) {
+	g.mu.Lock()
+	if g.calls == nil {
+		g.calls = make(map[string]*call[V])
+	}
+	c, ok := g.calls[key]
+	if !ok {
+		fctx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+		c = &call[V]{done: make(chan struct{}), cancel: cancel}
+		g.calls[key] = c
+		go g.run(fctx, key, c, f)
+	}
+	c.waiters++
+	g.mu.Unlock()
+
+	select {
+	case <-c.done:
+		return c.v, c.err
+	case <-ctx.Done():
+		g.mu.Lock()
+		c.waiters--
+		if c.waiters == 0 {
+			c.cancel()
+			// later callers start a new call rather than wait for
+			// the cancelled one.
+			g.forget(key, c)
+		}
+		g.mu.Unlock()
+		var zero V
+		return zero, ctx.Err()
+	}
+}
+
+// run runs the call c of f for key.
+func (g *Group[V]) run(ctx context.Context, key string, c *call[V], f func(ctx context.Context) (V, error)) {
+	defer func() {
+		if r := recover(); r != nil {
+			c.err = fmt.Errorf("singleflight: call panicked: %v", r)
+		}
+		g.mu.Lock()
+		g.forget(key, c)
+		g.mu.Unlock()
+		c.cancel()
+		close(c.done)
+	}()
+	c.v, c.err = f(ctx)
+}
+
+// forget removes the call c for key from the calls in flight, unless it
+// was already replaced.
+// g.mu must be held.
+func (g *Group[V]) forget(key string, c *call[V]) {
+	if g.calls[key] == c {
+		delete(g.calls, key)
+	}
+}
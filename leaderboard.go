@@ -0,0 +1,194 @@
+// Copyright 2020 The covid19 Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/sbinet/covid19/data"
+	"github.com/sbinet/covid19/internal/logctx"
+	"go-hep.org/x/hep/hplot"
+	"gonum.org/v1/plot/vg"
+	"gonum.org/v1/plot/vg/draw"
+)
+
+func leaderboardHandle(w http.ResponseWriter, req *http.Request) {
+	ctx := req.Context()
+	title, err := parseTitle(req)
+	if err != nil {
+		imageError(w, err.Error())
+		return
+	}
+
+	n := 10
+	if v := req.FormValue("n"); v != "" {
+		var err error
+		n, err = strconv.Atoi(v)
+		if err != nil || n < 1 || n > 50 {
+			imageError(w, fmt.Sprintf("invalid n value %q", v))
+			return
+		}
+	}
+
+	opts, err := parseOptions(req)
+	if err != nil {
+		optionsError(w, err)
+		return
+	}
+
+	if date, err := dataDate(ctx, title, opts); err == nil && notModified(w, req, date, opts.Format) {
+		return
+	}
+
+	fig, err := genLeaderboard(ctx, title, n, opts)
+	if err != nil {
+		logctx.From(ctx).Error("could not serve request", "error", err)
+		imageError(w, err.Error())
+		return
+	}
+
+	err = encodeImage(w, fig, opts)
+	if err != nil {
+		logctx.From(ctx).Error("could not serve request", "error", err)
+		imageError(w, err.Error())
+		return
+	}
+}
+
+// genLeaderboard renders a table of the n countries with the highest
+// latest value, together with their last daily increase.
+func genLeaderboard(ctx context.Context, title string, n int, opts Options) (figure, error) {
+	ds, err := data.Fetch(ctx, title, 0, nil, opts.Options)
+	if err != nil {
+		return figure{}, fmt.Errorf("could not fetch data: %w", err)
+	}
+	names := topN(ds, n)
+
+	var (
+		head = hplot.DefaultStyle.Fonts.Title
+		body = hplot.DefaultStyle.Fonts.Legend
+
+		pad  = 5 * vg.Millimeter
+		rowH = 1.6 * body.Size
+		rows = len(names) + 3 // title, blank line, columns header
+
+		width  = 12 * vg.Centimeter
+		height = 2*pad + vg.Length(rows)*rowH
+	)
+
+	th := opts.theme()
+	table := func(c draw.Canvas) {
+		th.fill(c)
+		text := func(font vg.Font, row int, x vg.Length, align draw.XAlignment, txt string) {
+			sty := draw.TextStyle{
+				Color:  th.Foreground,
+				Font:   font,
+				XAlign: align,
+				YAlign: draw.YTop,
+			}
+			c.FillText(sty, vg.Point{X: x, Y: c.Max.Y - pad - vg.Length(row)*rowH}, txt)
+		}
+
+		var (
+			colRank    = c.Min.X + pad
+			colCountry = colRank + 3*body.Size
+			colLatest  = c.Max.X - pad - 7*body.Size
+			colDelta   = c.Max.X - pad
+		)
+
+		text(head, 0, colRank, draw.XLeft, fmt.Sprintf(
+			"CoVid-19 - %s - %s", title, ds.Date.Format("2006-01-02"),
+		))
+		text(head, 2, colCountry, draw.XLeft, "Country")
+		text(head, 2, colLatest, draw.XRight, "Total")
+		text(head, 2, colDelta, draw.XRight, "Today")
+		for i, name := range names {
+			ys := ds.Table[name]
+			row := i + 3
+			text(body, row, colRank, draw.XLeft, strconv.Itoa(i+1)+".")
+			text(body, row, colCountry, draw.XLeft, name)
+			text(body, row, colLatest, draw.XRight, formatCount(latest(ys)))
+			text(body, row, colDelta, draw.XRight, formatDelta(delta(ys)))
+		}
+	}
+
+	return figure{drawer: drawFunc(table), width: width, height: height}, nil
+}
+
+// topN returns the names of the n countries with the highest latest value,
+// in decreasing order.
+func topN(ds data.Dataset, n int) []string {
+	names := make([]string, 0, len(ds.Table))
+	for name := range ds.Table {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		vi := latest(ds.Table[names[i]])
+		vj := latest(ds.Table[names[j]])
+		if vi != vj {
+			return vi > vj
+		}
+		return names[i] < names[j]
+	})
+	if len(names) > n {
+		names = names[:n]
+	}
+	return names
+}
+
+// latest returns the last finite value of the series, or 0.
+func latest(ys []float64) float64 {
+	for i := len(ys) - 1; i >= 0; i-- {
+		if v := ys[i]; !math.IsNaN(v) && !math.IsInf(v, 0) {
+			return v
+		}
+	}
+	return 0
+}
+
+// delta returns the difference between the last two values of the series.
+func delta(ys []float64) float64 {
+	if len(ys) < 2 {
+		return 0
+	}
+	v := ys[len(ys)-1] - ys[len(ys)-2]
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return 0
+	}
+	return v
+}
+
+// formatDelta formats v as a signed integer with thousands separators.
+func formatDelta(v float64) string {
+	if v < 0 {
+		return formatCount(v)
+	}
+	return "+" + formatCount(v)
+}
+
+// formatCount formats v as an integer with thousands separators.
+func formatCount(v float64) string {
+	str := strconv.FormatInt(int64(math.Round(v)), 10)
+	neg := strings.HasPrefix(str, "-")
+	str = strings.TrimPrefix(str, "-")
+
+	var o strings.Builder
+	for i, r := range str {
+		if i > 0 && (len(str)-i)%3 == 0 {
+			o.WriteByte(',')
+		}
+		o.WriteRune(r)
+	}
+	if neg {
+		return "-" + o.String()
+	}
+	return o.String()
+}
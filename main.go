@@ -5,278 +5,1750 @@
 package main
 
 import (
-	"encoding/csv"
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"flag"
 	"fmt"
-	"image"
+	"hash/fnv"
+	"html/template"
 	"image/color"
-	"image/png"
 	"io"
-	"log"
+	"log/slog"
 	"math"
 	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
+	"slices"
+	"sort"
 	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
+	"github.com/sbinet/covid19/data"
+	"github.com/sbinet/covid19/internal/logctx"
 	"go-hep.org/x/hep/hplot"
-	"gonum.org/v1/gonum/floats"
 	"gonum.org/v1/plot"
+	"gonum.org/v1/plot/plotter"
 	"gonum.org/v1/plot/plotutil"
 	"gonum.org/v1/plot/vg"
 	"gonum.org/v1/plot/vg/draw"
-	"gonum.org/v1/plot/vg/vgimg"
 )
 
 func main() {
-	log.SetPrefix("covid19: ")
-	log.SetFlags(0)
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "render":
+			renderCmd(os.Args[2:])
+			return
+		case "fetch":
+			fetchCmd(os.Args[2:])
+			return
+		}
+	}
+
+	defaultAddr := ":8080"
+	if v := os.Getenv("COVID19_ADDR"); v != "" {
+		defaultAddr = v
+	}
+
+	var (
+		addr     = flag.String("addr", defaultAddr, "address to listen on, $COVID19_ADDR if set")
+		index    = flag.String("indexplots", "confirmed,deaths", "comma-separated list of datasets shown on the index page")
+		shutdown = flag.Duration("shutdown-timeout", 30*time.Second, "time given to the in-flight requests to complete on shutdown")
+	)
+	flag.DurationVar(&requestTimeout, "request-timeout", requestTimeout, "time after which a request is abandoned (unbounded if zero)")
+	setup := dataFlags(flag.CommandLine)
+	flag.StringVar(&snapshotDir, "snapshot-dir", "", "directory where /admin/snapshot writes snapshots of the plots (disabled if empty)")
+	flag.DurationVar(&data.CacheTTL, "cache-ttl", data.CacheTTL, "time after which the cached data files are refreshed, without -refresh")
+	refresh := flag.Duration("refresh", 1*time.Hour, "interval between the scheduled updates of the data files (updated on expiry of the cache if zero)")
+	flag.Parse()
+	setup()
+
+	var plots []string
+	for _, title := range strings.Split(*index, ",") {
+		title = strings.TrimSpace(title)
+		if _, ok := cutoffs[title]; !ok {
+			fmt.Fprintf(os.Stderr, "covid19: invalid index plot %q\n", title)
+			os.Exit(2)
+		}
+		plots = append(plots, title)
+	}
+
+	if data.CacheTTL <= 0 {
+		fmt.Fprintf(os.Stderr, "covid19: invalid cache TTL %v\n", data.CacheTTL)
+		os.Exit(2)
+	}
+	if *refresh < 0 {
+		fmt.Fprintf(os.Stderr, "covid19: invalid refresh interval %v\n", *refresh)
+		os.Exit(2)
+	}
+	if requestTimeout < 0 {
+		fmt.Fprintf(os.Stderr, "covid19: invalid request timeout %v\n", requestTimeout)
+		os.Exit(2)
+	}
+	if *shutdown <= 0 {
+		fmt.Fprintf(os.Stderr, "covid19: invalid shutdown timeout %v\n", *shutdown)
+		os.Exit(2)
+	}
+
+	data.Metrics = srvMetrics
 
-	http.HandleFunc("/", rootHandle)
-	http.HandleFunc("/img-confirmed", imgHandle("confirmed", 100))
-	http.HandleFunc("/img-deaths", imgHandle("deaths", 10))
-	log.Printf("ready to serve...")
-	http.ListenAndServe(":8080", nil)
+	if data.ArchiveDir != "" {
+		err := data.RestoreArchive(context.Background())
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "covid19: could not restore archived data: %+v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	http.HandleFunc("/", instrument("root", rootHandle(plots)))
+	http.HandleFunc("/img-confirmed", instrument("img-confirmed", imgHandle("confirmed", 100)))
+	http.HandleFunc("/img-deaths", instrument("img-deaths", imgHandle("deaths", 10)))
+	http.HandleFunc("/img-deaths-per-million", instrument("img-deaths-per-million", perMillionHandle()))
+	http.HandleFunc("/img-recovered", instrument("img-recovered", imgHandle("recovered", 100)))
+	http.HandleFunc("/img-active", instrument("img-active", imgHandle("active", 100)))
+	http.HandleFunc("/img-cfr", instrument("img-cfr", cfrHandle()))
+	http.HandleFunc("/img-leaderboard", instrument("img-leaderboard", leaderboardHandle))
+	http.HandleFunc("/img-compare", instrument("img-compare", compareHandle))
+	http.HandleFunc("/img-combined", instrument("img-combined", stackHandle))
+	http.HandleFunc("/data", instrument("data", dataHandle))
+	http.HandleFunc("/api/v1/series", instrument("api-series", dataHandle))
+	http.HandleFunc("/api/v1/countries", instrument("api-countries", countryListHandle))
+	http.HandleFunc("/api/v1/regions", instrument("api-regions", regionsHandle))
+	http.HandleFunc("/api/v1/annotations", instrument("api-annotations", annotationsHandle))
+	http.HandleFunc("/countries", instrument("countries", countriesHandle))
+	http.HandleFunc("/csv-confirmed", instrument("csv-confirmed", csvHandle("confirmed", 100)))
+	http.HandleFunc("/csv-deaths", instrument("csv-deaths", csvHandle("deaths", 10)))
+	http.HandleFunc("/metrics", metricsHandle)
+	if snapshotDir != "" {
+		http.HandleFunc("/admin/snapshot", instrument("admin-snapshot", snapshotHandle(plots)))
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if *refresh > 0 {
+		go func() {
+			err := data.Refresh(ctx, data.Backend, *refresh)
+			if err != nil && !errors.Is(err, context.Canceled) {
+				slog.Error("could not refresh data", "error", err)
+			}
+		}()
+	}
+
+	srv := &http.Server{
+		Addr:              *addr,
+		ReadHeaderTimeout: 10 * time.Second,
+		IdleTimeout:       2 * time.Minute,
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		<-ctx.Done()
+		stop() // a second signal kills the process.
+		slog.Info("shutting down...", "timeout", *shutdown)
+
+		// in-flight requests, and the renders they wait for, are drained
+		// before exiting.
+		ctx, cancel := context.WithTimeout(context.Background(), *shutdown)
+		defer cancel()
+		if err := srv.Shutdown(ctx); err != nil {
+			slog.Error("could not shut down server", "error", err)
+			os.Exit(1)
+		}
+		slog.Info("shutdown complete")
+	}()
+
+	slog.Info("ready to serve...", "addr", *addr)
+	err := srv.ListenAndServe()
+	if !errors.Is(err, http.ErrServerClosed) {
+		slog.Error("could not serve", "error", err)
+		os.Exit(1)
+	}
+	<-done
 }
 
-func rootHandle(w http.ResponseWriter, req *http.Request) {
-	fmt.Fprintf(w, page)
+// dataFlags registers on fs the flags shared by the server and the
+// commands, which configure the logging, the retrieval of the data and
+// the policy events drawn on the plots.
+// The returned function applies them once fs is parsed, and exits on
+// invalid values.
+func dataFlags(fs *flag.FlagSet) func() {
+	var (
+		lvl = fs.String("loglevel", "info", "log level (debug, info, warn, error)")
+		ovr = fs.String("overrides", "overrides.csv", "CSV file with corrections of the upstream data (ignored if missing)")
+		ann = fs.String("annotations", "", "CSV file with the policy events drawn on the plots (built-in events if empty)")
+		src = fs.String("source", "jhu", "data source (jhu, ecdc, owid)")
+		url = fs.String("data-source", "", "base URL, or local directory, of the CSSE time series data files with -source=jhu, or URL, or local path, of the data file of the other sources (default location of the source if empty)")
+	)
+	fs.StringVar(&data.ArchiveDir, "archive-dir", "", "directory where to archive a copy of each version of the data files, read back with the asof option (disabled if empty)")
+	db := fs.String("db", "", "SQLite database where the series are persisted and served from (disabled if empty)")
+	fs.StringVar(&data.Dir, "data-dir", "", "directory the data files are read from, as written by the fetch command, instead of the data source")
+	fs.StringVar(&defaultLevel, "level", defaultLevel, "default geographic level of the series (country, state, county)")
+	fs.DurationVar(&data.Client.Timeout, "fetch-timeout", data.Client.Timeout, "timeout of a single request to the data source")
+	fs.IntVar(&data.Attempts, "fetch-attempts", data.Attempts, "number of attempts of each download from the data source, or a mirror")
+	fs.DurationVar(&data.Backoff, "fetch-backoff", data.Backoff, "delay before retrying a failed download, doubled after each attempt")
+	mirrors := fs.String("mirrors", "", "comma-separated list of base URLs, or local directories, holding copies of the data files as written by the fetch command, tried when the data source fails")
+
+	return func() {
+		var level slog.Level
+		if err := level.UnmarshalText([]byte(*lvl)); err != nil {
+			fmt.Fprintf(os.Stderr, "covid19: invalid log level %q: %+v\n", *lvl, err)
+			os.Exit(2)
+		}
+		slog.SetDefault(newLogger(os.Stderr, level))
+
+		switch defaultLevel {
+		case "country", "state", "county":
+		default:
+			fmt.Fprintf(os.Stderr, "covid19: invalid level %q\n", defaultLevel)
+			os.Exit(2)
+		}
+
+		if data.Attempts < 1 {
+			fmt.Fprintf(os.Stderr, "covid19: invalid number of fetch attempts %d\n", data.Attempts)
+			os.Exit(2)
+		}
+		if data.Backoff < 0 {
+			fmt.Fprintf(os.Stderr, "covid19: invalid fetch backoff %v\n", data.Backoff)
+			os.Exit(2)
+		}
+		for _, m := range strings.Split(*mirrors, ",") {
+			if m = strings.TrimSpace(m); m != "" {
+				data.Mirrors = append(data.Mirrors, m)
+			}
+		}
+
+		var err error
+		data.Backend, err = data.NewSource(*src, *url)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "covid19: %+v\n", err)
+			os.Exit(2)
+		}
+		if *src != "jhu" && defaultLevel != "country" {
+			fmt.Fprintf(os.Stderr, "covid19: the %s data is not available at the %s level\n", *src, defaultLevel)
+			os.Exit(2)
+		}
+
+		if *db != "" {
+			if sqlDriver == "" {
+				fmt.Fprintf(os.Stderr, "covid19: -db is not supported by builds with the nosqlite tag\n")
+				os.Exit(2)
+			}
+			sqldb, err := sql.Open(sqlDriver, *db)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "covid19: could not open database %q: %+v\n", *db, err)
+				os.Exit(1)
+			}
+			data.Backend, err = data.NewDB(context.Background(), sqldb, *src, data.Backend)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "covid19: could not open database %q: %+v\n", *db, err)
+				os.Exit(1)
+			}
+		}
+
+		data.Overrides, err = data.LoadOverrides(*ovr)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "covid19: could not load overrides from %q: %+v\n", *ovr, err)
+			os.Exit(1)
+		}
+
+		if *ann != "" {
+			annotations, err = loadAnnotations(*ann)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "covid19: could not load annotations from %q: %+v\n", *ann, err)
+				os.Exit(1)
+			}
+		}
+	}
 }
 
-func imgHandle(title string, cutoff float64) func(w http.ResponseWriter, req *http.Request) {
+// newLogger creates a text logger writing to w, with messages
+// prefixed by "covid19: " and without timestamps.
+func newLogger(w io.Writer, level slog.Level) *slog.Logger {
+	return slog.New(slog.NewTextHandler(prefixWriter{w: w}, &slog.HandlerOptions{
+		Level: level,
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			if a.Key == slog.TimeKey && len(groups) == 0 {
+				return slog.Attr{}
+			}
+			return a
+		},
+	}))
+}
+
+// prefixWriter prefixes each log record with the program name.
+type prefixWriter struct {
+	w io.Writer
+}
+
+func (pw prefixWriter) Write(p []byte) (int, error) {
+	_, err := pw.w.Write(append([]byte("covid19: "), p...))
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// newRequestID returns a random identifier for a request.
+func newRequestID() string {
+	var id [6]byte
+	_, _ = rand.Read(id[:])
+	return hex.EncodeToString(id[:])
+}
+
+func rootHandle(plots []string) func(w http.ResponseWriter, req *http.Request) {
 	return func(w http.ResponseWriter, req *http.Request) {
-		img, err := genImage(title, cutoff)
+		ctx := req.Context()
+		opts, err := parseOptions(req)
 		if err != nil {
-			log.Printf("error: %+v", err)
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
 
-		err = png.Encode(w, img)
+		pg := page{
+			Level:     opts.Level,
+			Countries: opts.Countries,
+			Daily:     opts.Daily,
+			Scale:     opts.Scale,
+		}
+		// the plots are drawn with the options of the page.
+		for _, title := range plots {
+			u := url.URL{Path: "/img-" + title, RawQuery: req.URL.RawQuery}
+			pg.Plots = append(pg.Plots, pagePlot{Title: title, URL: u.String()})
+		}
+		// options not set by the form are kept when it is submitted.
+		query := req.URL.Query()
+		keys := make([]string, 0, len(query))
+		for k := range query {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			switch k {
+			case "countries", "diff", "scale":
+				continue
+			}
+			for _, v := range query[k] {
+				pg.Hidden = append(pg.Hidden, [2]string{k, v})
+			}
+		}
+
+		err = pageTmpl.Execute(w, pg)
 		if err != nil {
-			log.Printf("error: %+v", err)
+			logctx.From(ctx).Error("could not serve request", "error", err)
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
+	}
+}
 
-		f, err := os.Create("covid-" + strings.ToLower(title) + ".png")
+func imgHandle(title string, cutoff float64) func(w http.ResponseWriter, req *http.Request) {
+	return func(w http.ResponseWriter, req *http.Request) {
+		ctx := req.Context()
+		opts, err := parseOptions(req)
 		if err != nil {
-			log.Printf("error: %+v", err)
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			optionsError(w, err)
+			return
+		}
+
+		// the plot only changes with the data, so it is not rendered again
+		// for clients that already have it.
+		date, err := dataDate(ctx, title, opts)
+		cached := err == nil
+		if cached && notModified(w, req, date, opts.Format) {
+			return
+		}
+
+		// the plot does not depend on the order of the query parameters.
+		key := title + "." + opts.Format + "?" + req.URL.Query().Encode()
+		if cached {
+			if raw, ok := imageCache.get(key, date); ok {
+				err = writeImage(w, raw, opts)
+				if err != nil {
+					logctx.From(ctx).Error("could not serve request", "error", err)
+				}
+				return
+			}
+		}
+
+		// concurrent identical requests share a single fetch and render,
+		// which is only abandoned once all their clients went away.
+		raw, err := imageFlight.Do(ctx, key, func(ctx context.Context) ([]byte, error) {
+			start := time.Now()
+			fig, err := genImage(ctx, title, cutoff, opts)
+			if err != nil {
+				return nil, err
+			}
+			// rendering is the costly part, not worth it without clients.
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+			raw, err := render(fig, opts)
+			if err != nil {
+				return nil, err
+			}
+			logctx.From(ctx).Debug("image generated", "title", title, "duration", time.Since(start))
+			if cached {
+				imageCache.put(key, date, raw)
+			}
+			return raw, nil
+		})
+		if errors.Is(err, context.Canceled) && ctx.Err() != nil {
+			logctx.From(ctx).Info("request abandoned by client", "title", title)
+			return
 		}
-		defer f.Close()
-		err = png.Encode(f, img)
 		if err != nil {
-			log.Printf("error: %+v", err)
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			logctx.From(ctx).Error("could not serve request", "title", title, "error", err)
+			imageError(w, err.Error())
+			return
+		}
+
+		err = writeImage(w, raw, opts)
+		if err != nil {
+			logctx.From(ctx).Error("could not serve request", "error", err)
+			return
 		}
 	}
 }
 
-func genImage(title string, cutoff float64) (image.Image, error) {
-	countries := []string{
-		"France",
-		"Italy",
-		"Spain",
-		//	"Korea, South",
-		//	"China",
-		"Germany",
-		"US",
-		"United Kingdom",
+// dataDate returns the date of the latest data of the title dataset.
+func dataDate(ctx context.Context, title string, opts Options) (time.Time, error) {
+	return data.Latest(ctx, title, opts.Options)
+}
+
+// notModified sets the caching headers of the response to req, for a
+// plot (or a table) of the data of date encoded with format. It reports
+// whether the client already has that version of the response, in which
+// case a 304 response was sent.
+func notModified(w http.ResponseWriter, req *http.Request, date time.Time, format string) bool {
+	// the plot is identified by the data it shows and how it is drawn.
+	h := fnv.New64a()
+	fmt.Fprintf(h, "%s|%s|%s?%s", date.Format("2006-01-02"), format, req.URL.Path, req.URL.RawQuery)
+	etag := fmt.Sprintf(`"%x"`, h.Sum64())
+
+	hdr := w.Header()
+	// the format may be negotiated from the Accept header.
+	hdr.Set("Vary", "Accept")
+	hdr.Set("ETag", etag)
+	hdr.Set("Last-Modified", date.UTC().Format(http.TimeFormat))
+	hdr.Set("Cache-Control", fmt.Sprintf("max-age=%d", int(data.CacheTTL.Seconds())))
+
+	match := false
+	if v := req.Header.Get("If-None-Match"); v != "" {
+		for _, tag := range strings.Split(v, ",") {
+			tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+			if tag == etag || tag == "*" {
+				match = true
+				break
+			}
+		}
+	} else if v := req.Header.Get("If-Modified-Since"); v != "" {
+		since, err := http.ParseTime(v)
+		match = err == nil && !date.After(since)
+	}
+	if match {
+		w.WriteHeader(http.StatusNotModified)
+	}
+	return match
+}
+
+// errStatus returns the HTTP status code corresponding to err.
+func errStatus(err error) int {
+	var unknown *data.UnknownCountriesError
+	if errors.As(err, &unknown) {
+		return http.StatusBadRequest
+	}
+	if errors.Is(err, data.ErrNotArchived) {
+		return http.StatusNotFound
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusInternalServerError
+}
+
+// canonicalName returns the name used by the data for the country name,
+// which may be a common variant of that name or its ISO code.
+// The names of the US states and counties, and of the provinces, are not
+// normalized, their abbreviations clashing with the country codes.
+func (opts Options) canonicalName(name string) string {
+	if opts.Level != "" && opts.Level != "country" {
+		return strings.TrimSpace(name)
+	}
+	return data.CanonicalName(name)
+}
+
+// Options holds the plotting options that can be tuned with the
+// query parameters of a request.
+type Options struct {
+	data.Options // geographic level, last day and handling of negative values
+
+	Countries []string // countries (or US states) to display
+	Sort      string   // order of the legend: "none" (as requested), "latest" or "name"
+	Highlight string   // country drawn prominently over the others, if any
+	RatioTo   string   // country the series are divided by, if any
+	Daily     bool     // whether to display daily new values instead of cumulative ones
+	Weekly    bool     // whether to display the change over the previous week, in percent
+	Derived   string   // quantity derived from the values: "doubling" time, "growth" rate, or none
+	Smooth    int      // window of the moving average, in days, or 0
+	Trailing  bool     // whether the moving average ends on each day instead of being centered
+
+	Anchor string // day 0 of the x-axis: "cutoff", "lockdown" or "date" for calendar dates
+
+	LockdownLabels bool // whether to annotate lockdown lines with their date
+
+	Scale string // scale of the y-axis: "log" or "linear"
+
+	Chart   string // kind of chart: "line", "combo" or "trajectory"
+	Country string // country displayed by single-country charts
+
+	Precision int // number of decimal places of displayed values, or -1 for defaults
+
+	SelfCompare string      // country whose waves are overlaid on each other
+	Waves       []time.Time // dates splitting the waves of SelfCompare
+
+	Lockdowns map[string][]Period // intervention periods shaded for each country
+
+	Growth []float64 // fractional daily growth rates of the exponential guidelines
+	Fit    int       // number of days of the exponential fit of each series, or 0
+
+	PerCapita float64 // number of people values are normalized to, or 0 for raw counts
+	PerCutoff bool    // whether the cutoff is a number per PerCapita people
+	Index     float64 // value series are rebased to at day 0, or 0 for raw values
+	R0        float64 // basic reproduction number of the herd immunity reference, or 0
+
+	Cutoff float64 // threshold series are aligned on, or 0 for the dataset default
+
+	Days int // maximum number of days plotted after the cutoff, or 0
+
+	Theme  string // name of the color theme
+	Format string // output format of the image
+	Width  int    // width of raster images, in pixels, or 0 for the default
+	Height int    // height of raster images, in pixels, or 0 for the default
+	DPI    int    // resolution of raster images, in dots per inch, or 0 for the default
+}
+
+// bounds of the requested image dimensions, in pixels.
+const (
+	minImageSize = 100
+	maxImageSize = 4000
+)
+
+// bounds of the requested image resolution, in dots per inch.
+const (
+	minImageDPI = 36
+	maxImageDPI = 600
+)
+
+// theme returns the color theme requested by the options.
+func (opts Options) theme() theme {
+	return themes[opts.Theme]
+}
+
+// cutoff returns the cutoff requested by the options, or def.
+func (opts Options) cutoff(def float64) float64 {
+	if opts.Cutoff > 0 {
+		return opts.Cutoff
+	}
+	return def
+}
+
+// Period is a range of dates.
+type Period struct {
+	Beg time.Time
+	End time.Time
+}
+
+func parseOptions(req *http.Request) (Options, error) {
+	opts := Options{
+		Options: data.Options{
+			Level:     defaultLevel,
+			Negatives: "clamp",
+		},
+		Countries: defaultCountries,
+		Anchor:    "cutoff",
+		Scale:     "log",
+		Chart:     "line",
+		Country:   "France",
+		Precision: -1,
+		Growth:    []float64{0.33},
+		Theme:     "light",
+		Sort:      "none",
+	}
+
+	if v := req.FormValue("level"); v != "" {
+		switch v {
+		case "country", "state", "county":
+			opts.Level = v
+		default:
+			return opts, fmt.Errorf("invalid level value %q", v)
+		}
+	}
+	switch opts.Level {
+	case "state":
+		opts.Countries = defaultStates
+		opts.Country = "New York"
+	case "county":
+		opts.Countries = defaultCounties
+		opts.Country = "New York (New York)"
+	}
+
+	if v := req.FormValue("countries"); v != "" {
+		opts.Countries = nil
+		for _, name := range strings.Split(v, ",") {
+			name = opts.canonicalName(name)
+			if name == "" {
+				continue
+			}
+			opts.Countries = append(opts.Countries, name)
+		}
+		if len(opts.Countries) == 0 {
+			return opts, fmt.Errorf("invalid countries value %q", v)
+		}
+	}
+
+	if v := req.FormValue("sort"); v != "" {
+		switch v {
+		case "none", "latest", "name":
+			opts.Sort = v
+		default:
+			return opts, fmt.Errorf("invalid sort value %q", v)
+		}
+	}
+
+	if v := req.FormValue("diff"); v != "" {
+		ok, err := strconv.ParseBool(v)
+		if err != nil {
+			return opts, fmt.Errorf("invalid diff value %q: %w", v, err)
+		}
+		opts.Daily = ok
+		if opts.Daily {
+			// daily values go down to zero, which a log scale can not display.
+			opts.Scale = "linear"
+		}
+	}
+
+	if v := req.FormValue("wow"); v != "" {
+		ok, err := strconv.ParseBool(v)
+		if err != nil {
+			return opts, fmt.Errorf("invalid wow value %q: %w", v, err)
+		}
+		opts.Weekly = ok
+		if opts.Weekly {
+			// changes are negative when the values decrease.
+			opts.Scale = "linear"
+		}
+	}
+
+	if v := req.FormValue("derived"); v != "" {
+		switch v {
+		case "doubling", "growth":
+			opts.Derived = v
+		default:
+			return opts, fmt.Errorf("invalid derived value %q", v)
+		}
+		if opts.Weekly {
+			return opts, fmt.Errorf("derived can not be combined with wow")
+		}
+		// growth rates are negative when the values decrease.
+		opts.Scale = "linear"
+	}
+
+	if v := req.FormValue("scale"); v != "" {
+		switch v {
+		case "log", "linear":
+			opts.Scale = v
+		default:
+			return opts, fmt.Errorf("invalid scale value %q", v)
+		}
+	}
+
+	if v := req.FormValue("growth"); v != "" {
+		opts.Growth = nil
+		for _, tok := range strings.Split(v, ",") {
+			rate, err := strconv.ParseFloat(strings.TrimSpace(tok), 64)
+			if err != nil || rate <= 0 || rate > 10 {
+				return opts, fmt.Errorf("invalid growth value %q", tok)
+			}
+			opts.Growth = append(opts.Growth, rate)
+		}
+	}
+
+	if v := req.FormValue("fit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 2 || n > 90 {
+			return opts, fmt.Errorf("invalid fit value %q", v)
+		}
+		opts.Fit = n
+	}
+
+	if v := req.FormValue("smooth"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 90 {
+			return opts, fmt.Errorf("invalid smooth value %q", v)
+		}
+		opts.Smooth = n
+	}
+
+	if v := req.FormValue("smoothing"); v != "" {
+		switch v {
+		case "centered", "trailing":
+			opts.Trailing = v == "trailing"
+		default:
+			return opts, fmt.Errorf("invalid smoothing value %q", v)
+		}
+	}
+
+	if v := req.FormValue("anchor"); v != "" {
+		switch v {
+		case "cutoff", "lockdown", "date":
+			opts.Anchor = v
+		default:
+			return opts, fmt.Errorf("invalid anchor value %q", v)
+		}
+	}
+
+	if v := req.FormValue("align"); v != "" {
+		switch v {
+		case "cutoff", "date":
+			opts.Anchor = v
+		default:
+			return opts, fmt.Errorf("invalid align value %q", v)
+		}
+	}
+
+	if v := req.FormValue("lockdownlabels"); v != "" {
+		ok, err := strconv.ParseBool(v)
+		if err != nil {
+			return opts, fmt.Errorf("invalid lockdownlabels value %q: %w", v, err)
+		}
+		opts.LockdownLabels = ok
+	}
+
+	if v := req.FormValue("negatives"); v != "" {
+		switch v {
+		case "clamp", "drop", "keep":
+			opts.Negatives = v
+		default:
+			return opts, fmt.Errorf("invalid negatives value %q", v)
+		}
+	}
+
+	if v := req.FormValue("chart"); v != "" {
+		switch v {
+		case "line", "combo", "trajectory":
+			opts.Chart = v
+		default:
+			return opts, fmt.Errorf("invalid chart value %q", v)
+		}
+	}
+
+	if v := req.FormValue("country"); v != "" {
+		opts.Country = opts.canonicalName(v)
+	}
+
+	if v := req.FormValue("region"); v != "" {
+		// region=Hubei,Beijing&country=China, or region=all for all the
+		// provinces of the country.
+		switch {
+		case opts.Level != "country":
+			return opts, fmt.Errorf("region is not available at the %s level", opts.Level)
+		case req.FormValue("country") == "":
+			return opts, fmt.Errorf("region requires a country value")
+		}
+		opts.Level = "province"
+		opts.Parent = opts.Country
+		opts.Countries = nil
+		if v == "all" {
+			names, err := provinces(req.Context(), opts.Options)
+			if err != nil {
+				return opts, err
+			}
+			opts.Countries = names
+		} else {
+			for _, name := range strings.Split(v, ",") {
+				if name = strings.TrimSpace(name); name != "" {
+					opts.Countries = append(opts.Countries, name)
+				}
+			}
+		}
+		if len(opts.Countries) == 0 {
+			return opts, fmt.Errorf("invalid region value %q", v)
+		}
+		// the combo chart shows the first region.
+		opts.Country = opts.Countries[0]
+	}
+
+	if v := req.FormValue("highlight"); v != "" {
+		opts.Highlight = opts.canonicalName(v)
+	}
+
+	if v := req.FormValue("precision"); v != "" {
+		prec, err := strconv.Atoi(v)
+		if err != nil || prec < 0 || prec > 6 {
+			return opts, fmt.Errorf("invalid precision value %q", v)
+		}
+		opts.Precision = prec
+	}
+
+	if v := req.FormValue("selfcompare"); v != "" {
+		opts.SelfCompare = opts.canonicalName(v)
+		waves := req.FormValue("waves")
+		if waves == "" {
+			return opts, fmt.Errorf("selfcompare requires a waves value")
+		}
+		for _, tok := range strings.Split(waves, ",") {
+			date, err := time.Parse("2006-01-02", strings.TrimSpace(tok))
+			if err != nil {
+				return opts, fmt.Errorf("invalid waves value %q: %w", tok, err)
+			}
+			opts.Waves = append(opts.Waves, date)
+		}
+		sort.Slice(opts.Waves, func(i, j int) bool {
+			return opts.Waves[i].Before(opts.Waves[j])
+		})
+	}
+
+	for _, v := range []struct {
+		name     string
+		alias    string
+		val      *int
+		min, max int
+	}{
+		{"w", "width", &opts.Width, minImageSize, maxImageSize},
+		{"h", "height", &opts.Height, minImageSize, maxImageSize},
+		{"dpi", "", &opts.DPI, minImageDPI, maxImageDPI},
+	} {
+		name, str := v.name, req.FormValue(v.name)
+		if str == "" && v.alias != "" {
+			name, str = v.alias, req.FormValue(v.alias)
+		}
+		if str == "" {
+			continue
+		}
+		n, err := strconv.Atoi(str)
+		if err != nil {
+			return opts, fmt.Errorf("invalid %s value %q: %w", name, str, err)
+		}
+		// bound the size of the allocated canvas.
+		*v.val = min(max(n, v.min), v.max)
 	}
-	ds, err := fetchData(title, cutoff, countries)
+
+	if v := req.FormValue("theme"); v != "" {
+		if _, ok := themes[v]; !ok {
+			return opts, fmt.Errorf("invalid theme value %q", v)
+		}
+		opts.Theme = v
+	}
+
+	if v := req.FormValue("cutoff"); v != "" {
+		cutoff, err := strconv.ParseFloat(v, 64)
+		if err != nil || cutoff <= 0 {
+			return opts, fmt.Errorf("invalid cutoff value %q", v)
+		}
+		opts.Cutoff = cutoff
+	}
+
+	if v := req.FormValue("until"); v != "" {
+		date, err := time.Parse("2006-01-02", v)
+		if err != nil {
+			return opts, fmt.Errorf("invalid until value %q: %w", v, err)
+		}
+		opts.Until = date
+	}
+
+	if v := req.FormValue("asof"); v != "" {
+		date, err := time.Parse("2006-01-02", v)
+		if err != nil {
+			return opts, fmt.Errorf("invalid asof value %q: %w", v, err)
+		}
+		opts.AsOf = date
+	}
+
+	if v := req.FormValue("days"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return opts, fmt.Errorf("invalid days value %q", v)
+		}
+		opts.Days = n
+	}
+
+	if v := req.FormValue("lockdowns"); v != "" {
+		// lockdowns=France:2020-03-17/2020-05-11,Italy:2020-03-09/2020-05-18
+		opts.Lockdowns = make(map[string][]Period)
+		for _, tok := range strings.Split(v, ",") {
+			i := strings.LastIndex(tok, ":")
+			if i < 0 {
+				return opts, fmt.Errorf("invalid lockdowns value %q", tok)
+			}
+			name := opts.canonicalName(tok[:i])
+			dates := strings.Split(tok[i+1:], "/")
+			if len(dates) != 2 {
+				return opts, fmt.Errorf("invalid lockdowns period %q", tok)
+			}
+			var period Period
+			for j, out := range []*time.Time{&period.Beg, &period.End} {
+				date, err := time.Parse("2006-01-02", strings.TrimSpace(dates[j]))
+				if err != nil {
+					return opts, fmt.Errorf("invalid lockdowns date %q: %w", dates[j], err)
+				}
+				*out = date
+			}
+			if period.End.Before(period.Beg) {
+				return opts, fmt.Errorf("invalid lockdowns period %q: end before start", tok)
+			}
+			opts.Lockdowns[name] = append(opts.Lockdowns[name], period)
+		}
+	}
+
+	if v := req.FormValue("per"); v != "" {
+		per, err := strconv.ParseFloat(v, 64)
+		if err != nil || per <= 0 {
+			return opts, fmt.Errorf("invalid per value %q", v)
+		}
+		opts.PerCapita = per
+	}
+
+	if v := req.FormValue("percutoff"); v != "" {
+		ok, err := strconv.ParseBool(v)
+		if err != nil {
+			return opts, fmt.Errorf("invalid percutoff value %q: %w", v, err)
+		}
+		if ok && opts.PerCapita == 0 {
+			return opts, fmt.Errorf("percutoff requires a per value")
+		}
+		opts.PerCutoff = ok
+	}
+
+	if v := req.FormValue("index"); v != "" {
+		base, err := strconv.ParseFloat(v, 64)
+		if err != nil || base <= 0 {
+			return opts, fmt.Errorf("invalid index value %q", v)
+		}
+		opts.Index = base
+	}
+
+	if v := req.FormValue("herd"); v != "" {
+		r0, err := strconv.ParseFloat(v, 64)
+		if err != nil || r0 <= 1 {
+			return opts, fmt.Errorf("invalid herd value %q: R0 must be > 1", v)
+		}
+		opts.R0 = r0
+	}
+
+	if v := req.FormValue("ratioTo"); v != "" {
+		opts.RatioTo = opts.canonicalName(v)
+		switch {
+		case opts.Anchor == "lockdown":
+			return opts, fmt.Errorf("ratioTo needs series aligned on the cutoff or on dates")
+		case opts.Weekly:
+			return opts, fmt.Errorf("ratioTo can not be combined with wow")
+		case opts.Derived != "":
+			return opts, fmt.Errorf("ratioTo can not be combined with derived")
+		}
+		if !slices.Contains(opts.Countries, opts.RatioTo) {
+			opts.Countries = append(slices.Clone(opts.Countries), opts.RatioTo)
+		}
+	}
+
+	if opts.PerCapita > 0 {
+		// the populations of the aggregates are not known: the
+		// population data does not cover all of their countries.
+		aggregates := data.Aggregates()
+		for _, name := range opts.Countries {
+			if slices.Contains(aggregates, name) {
+				return opts, fmt.Errorf("per can not be combined with the aggregate %s", name)
+			}
+		}
+	}
+
+	format, err := parseFormat(req.FormValue("format"), req.Header.Get("Accept"))
+	if err != nil {
+		return opts, err
+	}
+	opts.Format = format
+
+	return opts, nil
+}
+
+// parseTitle returns the dataset requested with the "title" query parameter.
+func parseTitle(req *http.Request) (string, error) {
+	title := req.FormValue("title")
+	if title == "" {
+		return "confirmed", nil
+	}
+	if _, ok := cutoffs[title]; !ok {
+		return "", fmt.Errorf("invalid title %q", title)
+	}
+	return title, nil
+}
+
+func genImage(ctx context.Context, title string, cutoff float64, opts Options) (figure, error) {
+	const sz = 20 * vg.Centimeter
+	cutoff = opts.cutoff(cutoff)
+
+	// the case fatality ratio is not a count of new events.
+	if title == "cfr" {
+		switch {
+		case opts.Chart != "line" || opts.SelfCompare != "":
+			return figure{}, fmt.Errorf("the case fatality ratio is only available as a line chart")
+		case opts.Daily || opts.PerCapita > 0:
+			return figure{}, fmt.Errorf("the case fatality ratio can not be combined with diff or per")
+		}
+	}
+
+	var (
+		p   interface{ Draw(draw.Canvas) }
+		err error
+	)
+	switch {
+	case opts.SelfCompare != "":
+		p, err = genSelfCompare(ctx, title, cutoff, opts)
+	case opts.Chart == "combo":
+		p, err = genCombo(ctx, title, cutoff, sz*math.Phi, opts)
+	case opts.Chart == "trajectory":
+		p, err = genTrajectory(ctx, title, cutoff, opts)
+	default:
+		p, err = genPlot(ctx, title, cutoff, opts)
+	}
+	if err != nil {
+		return figure{}, err
+	}
+
+	return figure{drawer: p, width: sz * math.Phi, height: sz}, nil
+}
+
+func genPlot(ctx context.Context, title string, cutoff float64, opts Options) (*hplot.Plot, error) {
+	countries := opts.Countries
+	keep := cutoff
+	if opts.Anchor == "date" || opts.PerCutoff || opts.Daily {
+		// keep the full series on the calendar axis, or until they
+		// are normalized or differenced.
+		keep = 0
+	}
+	ds, err := data.Fetch(ctx, title, keep, countries, opts.Options)
 	if err != nil {
 		return nil, fmt.Errorf("could not fetch data: %w", err)
 	}
-	date := ds.date
-	dataset := ds.table
-	log.Printf("%s: data for %q", title, date.Format("2006-01-02"))
+	date := ds.Date
+	dataset := ds.Table
+	logctx.From(ctx).Info("data for", "title", title, "date", date.Format("2006-01-02"))
+
+	// i0 holds the index of the first day each series reached the cutoff
+	// on the calendar axis, where the series are not trimmed there.
+	i0 := make(map[string]int)
+
+	// trim trims the series at the cutoff, after computing their daily
+	// new values from the untrimmed ones: the value of the first day is
+	// then its change from the day before, instead of a spurious zero.
+	trim := func() {
+		var news map[string][]float64
+		if opts.Daily {
+			news = make(map[string][]float64, len(dataset))
+			for name, ys := range dataset {
+				news[name] = daily(ys)
+			}
+		}
+		switch {
+		case opts.Anchor == "date":
+			for name, ys := range dataset {
+				i0[name], _ = data.CutoffIndex(ys, cutoff)
+			}
+		case keep != cutoff:
+			ds.Align(cutoff)
+		}
+		for name, ys := range news {
+			dataset[name] = ys[len(ys)-len(dataset[name]):]
+		}
+	}
+
+	prec := titlePrec(title)
+	if !opts.PerCutoff {
+		trim()
+	}
+	if opts.PerCapita > 0 {
+		countries = data.PerCapita(ctx, &ds, countries, opts.PerCapita)
+		if opts.PerCutoff {
+			trim()
+		}
+		prec = precRate
+	}
+
+	if opts.Smooth > 1 {
+		for _, name := range countries {
+			dataset[name] = smooth(dataset[name], opts.Smooth, opts.Trailing)
+		}
+	}
 
+	// first holds the index of the first plotted day of each series in
+	// its untrimmed days: on the calendar axis, the days plotted after
+	// the cutoff start at the cutoff day.
+	first := make(map[string]int)
+	if opts.Days > 0 {
+		for _, name := range countries {
+			ys, i := dataset[name], i0[name]
+			if i >= len(ys) {
+				continue
+			}
+			dataset[name] = ys[i:min(i+opts.Days, len(ys))]
+			first[name], i0[name] = i, 0
+		}
+	}
+
+	if opts.Index > 0 {
+		var indexed []string
+		for _, name := range countries {
+			ys, ok := rebase(dataset[name], i0[name], opts.Index)
+			if !ok {
+				logctx.From(ctx).Warn("zero value at day 0, skipping", "title", title, "country", name)
+				continue
+			}
+			dataset[name] = ys
+			indexed = append(indexed, name)
+		}
+		countries = indexed
+		prec = precRate
+	}
+	if opts.RatioTo != "" {
+		ref, ok := dataset[opts.RatioTo]
+		if !ok || !slices.Contains(countries, opts.RatioTo) {
+			return nil, fmt.Errorf("no data for reference country %q", opts.RatioTo)
+		}
+		for _, name := range countries {
+			dataset[name] = ratio(dataset[name], ref)
+		}
+		prec = precRate
+	}
+	if opts.Weekly {
+		// the change is the same for raw, per-capita and indexed values.
+		for _, name := range countries {
+			dataset[name] = change(dataset[name], 7)
+		}
+		prec = precPercent
+	}
+	switch opts.Derived {
+	case "doubling":
+		for _, name := range countries {
+			dataset[name] = doublingTimes(dataset[name], doublingWindow)
+		}
+		prec = precDays
+	case "growth":
+		for _, name := range countries {
+			dataset[name] = change(dataset[name], 1)
+		}
+		prec = precPercent
+	}
+
+	// colors follow the requested order, whatever the order of the legend.
+	colors := make(map[string]int, len(countries))
+	for i, name := range countries {
+		colors[name] = i
+	}
+	countries = sortCountries(countries, dataset, opts.Sort)
+
+	highlight := opts.Highlight
+	if highlight != "" {
+		i := slices.Index(countries, highlight)
+		if i < 0 {
+			logctx.From(ctx).Info("highlighted country not plotted, ignoring", "title", title, "country", highlight)
+			highlight = ""
+		} else {
+			// the highlighted country is listed first.
+			countries = append([]string{highlight}, slices.Delete(slices.Clone(countries), i, i+1)...)
+		}
+	}
+
+	th := opts.theme()
 	p := hplot.New()
+	th.apply(p)
 	p.Title.Text = "CoVid-19 - " + title + " - " + date.Format("2006-01-02")
-	p.X.Label.Text = fmt.Sprintf("Days from first %d confirmed cases", int(cutoff))
+	p.X.Label.Text = cutoffLabel(title, cutoff)
+	if opts.PerCutoff {
+		p.X.Label.Text += " per " + perLabel(opts.PerCapita)
+	}
 	p.X.Tick.Marker = hplot.Ticks{N: 20}
-	p.Y.Scale = plot.LogScale{}
-	p.Y.Tick.Marker = plot.LogTicks{}
+	switch opts.Anchor {
+	case "lockdown":
+		p.X.Label.Text = "Days from lockdown"
+	case "date":
+		p.X.Label.Text = "Date"
+		p.X.Tick.Marker = dateTicks(ds.Start)
+	}
+	if title == "cfr" {
+		p.Y.Label.Text = "case fatality ratio (%)"
+	}
+	if opts.Daily || opts.PerCapita > 0 {
+		ylabel := title
+		if opts.Daily {
+			ylabel = "daily new " + title
+		}
+		if opts.PerCapita > 0 {
+			ylabel = fmt.Sprintf("%s per %s", ylabel, perLabel(opts.PerCapita))
+		}
+		p.Y.Label.Text = ylabel
+	}
+	if opts.Index > 0 {
+		p.Y.Label.Text = fmt.Sprintf("Indexed (cutoff = %g)", opts.Index)
+	}
+	if opts.Weekly {
+		ylabel := title
+		if opts.Daily {
+			ylabel = "daily new " + title
+		}
+		p.Y.Label.Text = "week-over-week change of " + ylabel + " (%)"
+	}
+	if opts.Derived != "" {
+		ylabel := title
+		if opts.Daily {
+			ylabel = "daily new " + title
+		}
+		switch opts.Derived {
+		case "doubling":
+			p.Y.Label.Text = fmt.Sprintf("doubling time of %s (days, over %d days)", ylabel, doublingWindow)
+		case "growth":
+			p.Y.Label.Text = "day-over-day growth of " + ylabel + " (%)"
+		}
+	}
+	if opts.RatioTo != "" {
+		ylabel := p.Y.Label.Text
+		if ylabel == "" {
+			ylabel = title
+		}
+		p.Y.Label.Text = fmt.Sprintf("%s, ratio to %s", ylabel, opts.RatioTo)
+	}
+	if opts.Scale == "log" {
+		p.Y.Scale = plot.LogScale{}
+		p.Y.Tick.Marker = plot.LogTicks{}
+	}
 
-	legends := make(map[string]plot.Thumbnailer)
-	for i, name := range countries {
+	// day returns the x-axis position of date for the series of a country,
+	// in days from the first day it reached the cutoff.
+	// Series are not trimmed on the calendar axis, so positions are then
+	// in days from the first day of the data.
+	day := ds.Day
+
+	// origin returns the x-axis position of day 0 for a country.
+	origin := func(name string) (float64, bool) {
+		if opts.Anchor != "lockdown" {
+			return 0, true
+		}
+		ev, ok := firstLockdown(name)
+		if !ok {
+			return 0, false
+		}
+		return day(name, ev.Date), true
+	}
+
+	// intervention periods are drawn first, behind the curves.
+	for _, name := range countries {
+		x0, ok := origin(name)
+		if !ok || len(dataset[name]) == 0 {
+			continue
+		}
+		for _, period := range opts.Lockdowns[name] {
+			c := color.NRGBAModel.Convert(th.color(colors[name])).(color.NRGBA)
+			c.A = 0x40
+			p.Add(&span{
+				X0:    day(name, period.Beg) - x0,
+				X1:    day(name, period.End) - x0,
+				Color: c,
+			})
+		}
+	}
+
+	if opts.RatioTo != "" {
+		// drawn behind the flat line of the reference country.
+		hline := hplot.HLine(1, nil, nil)
+		hline.Line.Color = th.Guide
+		hline.Line.Width = 2
+		p.Add(hline)
+	}
+
+	type legend struct {
+		label string
+		thumb plot.Thumbnailer
+	}
+	legends := make(map[string][]legend)
+	var top *plotter.Line // line of the highlighted country
+	for _, name := range countries {
 		ys := dataset[name]
+		if len(ys) == 0 {
+			logctx.From(ctx).Warn("no data, skipping", "title", title, "country", name)
+			continue
+		}
+		// series are only trimmed at the cutoff away from the calendar axis,
+		// so untrimmed ones would not be aligned with the others.
+		if !ds.Reached(name) && opts.Anchor != "date" {
+			logctx.From(ctx).Warn("cutoff never reached, skipping", "title", title, "country", name, "cutoff", cutoff)
+			continue
+		}
+		x0, ok := origin(name)
+		if !ok {
+			logctx.From(ctx).Warn("no lockdown date, skipping", "title", title, "country", name)
+			continue
+		}
 		xs := make([]float64, len(ys))
 		for i := range xs {
-			xs[i] = float64(i)
+			xs[i] = float64(first[name]+i) - x0
+		}
+		xs, ys = finite(xs, ys)
+		if opts.Scale == "log" {
+			xs, ys = positive(xs, ys)
+		}
+		if len(ys) == 0 {
+			logctx.From(ctx).Warn("no valid data, skipping", "title", title, "country", name)
+			continue
 		}
 		xys := hplot.ZipXY(xs, ys)
 		line, err := hplot.NewLine(xys)
 		if err != nil {
 			return nil, fmt.Errorf("could not create line plot for %q: %w", name, err)
 		}
-		line.Color = plotutil.SoftColors[i]
+		line.Color = th.color(colors[name])
 		line.Width = 2
-		p.Add(line)
-		p.Legend.Add(fmt.Sprintf("%s %8d", name, int(ys[len(ys)-1])), line)
-		if lockdown, ok := lockDB[name]; ok {
-			v := ds.cutoff[name]
-			start := ds.start
-			loc := start.Location()
-			beg := time.Date(start.Year(), start.Month(), start.Day()+v, 0, 0, 0, 0, loc)
-			lx := lockdown.Sub(beg).Hours() / 24
+		switch {
+		case name == highlight:
+			line.Width = 3
+			top = line
+		case highlight != "":
+			c := color.NRGBAModel.Convert(line.Color).(color.NRGBA)
+			c.A = 0x60
+			line.Color = c
+			line.Width = 1
+			p.Add(line)
+		default:
+			p.Add(line)
+		}
+		label := fmt.Sprintf(
+			"%s %8s  x2: %s", name, opts.format(ys[len(ys)-1], prec),
+			formatDoubling(doublingTime(dataset[name], doublingWindow)),
+		)
+		// doubling times and exponential fits need the values themselves.
+		switch {
+		case opts.Weekly, opts.Derived == "growth", title == "cfr":
+			label = fmt.Sprintf("%s %8s%%", name, opts.format(ys[len(ys)-1], prec))
+		case opts.Derived == "doubling":
+			// the doubling time of the last day may be infinite.
+			vs := dataset[name]
+			label = fmt.Sprintf("%s %8s", name, formatDoubling(opts.round(vs[len(vs)-1], prec)))
+		case opts.RatioTo != "":
+			label = fmt.Sprintf("%s %8sx", name, opts.format(ys[len(ys)-1], prec))
+		}
+		if opts.Fit > 0 && !opts.Weekly && opts.Derived == "" && opts.RatioTo == "" && title != "cfr" {
+			fit, ok, err := fitLine(xs, ys, opts.Fit, line.Color)
+			if err != nil {
+				return nil, fmt.Errorf("could not create fit line for %q: %w", name, err)
+			}
+			if ok {
+				p.Add(fit.line)
+				label += fmt.Sprintf("  fit: %+.1f%%/d", 100*fit.growth)
+			}
+		}
+		p.Legend.Add(label, line)
+		for j, ev := range annotations[name] {
+			if ev.Date.After(date) {
+				break
+			}
+			lx := day(name, ev.Date) - x0
 			vline := hplot.VLine(lx, nil, nil)
 			vline.Line.Color = line.Color
-			vline.Line.Dashes = plotutil.Dashes(1)
+			vline.Line.Dashes = plotutil.Dashes(j + 1)
 			vline.Line.Width = 2
 			p.Add(vline)
-			legends[name] = vline
+			legends[name] = append(legends[name], legend{ev.Label, vline})
+			if opts.LockdownLabels {
+				sty := p.Legend.TextStyle
+				sty.Color = line.Color
+				p.Add(&vlineLabel{
+					X:     lx,
+					Text:  ev.Label + " " + ev.Date.Format("2006-01-02"),
+					Style: sty,
+				})
+			}
 		}
 	}
-	fct := hplot.NewFunction(func(x float64) float64 {
-		return cutoff * math.Pow(1.33, x)
-	})
-	fct.LineStyle.Color = color.Gray16{}
-	fct.LineStyle.Width = 2
-	fct.LineStyle.Dashes = plotutil.Dashes(1)
-	p.Add(fct)
-	p.Legend.Add("33% daily growth", fct)
-	for _, name := range []string{"Italy", "France"} {
-		p.Legend.Add(fmt.Sprintf("%s - lockdown", name), legends[name])
+	if top != nil {
+		// drawn last, on top of the other lines.
+		p.Add(top)
 	}
-	p.Add(hplot.NewGrid())
-
-	const sz = 20 * vg.Centimeter
-	cnv := vgimg.PngCanvas{vgimg.New(sz*math.Phi, sz)}
+	// the exponential guidelines would dwarf the data on a linear scale,
+	// and is expressed in the units of the cutoff, or in the indexed values.
+	if opts.Anchor == "cutoff" && opts.Scale == "log" && (opts.PerCapita == 0 || opts.PerCutoff || opts.Index > 0) && !opts.Daily && opts.Derived == "" && opts.RatioTo == "" && title != "cfr" {
+		y0 := cutoff
+		if opts.Index > 0 {
+			y0 = opts.Index
+		}
+		for i, rate := range opts.Growth {
+			base := 1 + rate
+			fct := hplot.NewFunction(func(x float64) float64 {
+				return y0 * math.Pow(base, x)
+			})
+			fct.LineStyle.Color = th.Guide
+			fct.LineStyle.Width = 2
+			fct.LineStyle.Dashes = plotutil.Dashes(i + 1)
+			p.Add(fct)
+			p.Legend.Add(fmt.Sprintf("%g%% daily growth", math.Round(rate*1000)/10), fct)
+		}
+	}
+	// the herd immunity threshold is only meaningful relative to the population.
+	if opts.R0 > 0 && opts.PerCapita > 0 && opts.Index == 0 && !opts.Weekly && opts.Derived == "" && opts.RatioTo == "" {
+		y := herdImmunity(opts.R0) * opts.PerCapita
+		hline := hplot.HLine(y, nil, nil)
+		hline.Line.Color = th.Guide
+		hline.Line.Dashes = plotutil.Dashes(2)
+		hline.Line.Width = 2
+		p.Add(hline)
+		p.Legend.Add(fmt.Sprintf("~herd immunity (R0=%g)", opts.R0), hline)
+		// make sure the reference is visible.
+		if y > p.Y.Max {
+			p.Y.Max = y
+		}
+	}
+	for _, name := range countries {
+		for _, leg := range legends[name] {
+			p.Legend.Add(fmt.Sprintf("%s - %s", name, leg.label), leg.thumb)
+		}
+	}
+	if opts.Weekly {
+		// center the y-axis on the reference line of a steady value.
+		hline := hplot.HLine(0, nil, nil)
+		hline.Line.Color = th.Guide
+		hline.Line.Width = 2
+		p.Add(hline)
+		m := math.Max(math.Abs(p.Y.Min), math.Abs(p.Y.Max))
+		p.Y.Min, p.Y.Max = -m, m
+	}
+	p.Add(th.grid())
 
-	c := draw.New(cnv)
-	p.Draw(c)
-	return cnv.Image(), nil
+	return p, nil
 }
 
-type Dataset struct {
-	date   time.Time
-	start  time.Time
-	table  map[string][]float64
-	cutoff map[string]int
+// sortCountries returns countries in the order of the legend: as given,
+// by decreasing latest value of their series, or by name.
+func sortCountries(countries []string, dataset map[string][]float64, order string) []string {
+	out := make([]string, len(countries))
+	copy(out, countries)
+	switch order {
+	case "latest":
+		latest := func(name string) float64 {
+			ys := dataset[name]
+			for i := len(ys) - 1; i >= 0; i-- {
+				if !math.IsNaN(ys[i]) && !math.IsInf(ys[i], 0) {
+					return ys[i]
+				}
+			}
+			return math.Inf(-1)
+		}
+		sort.SliceStable(out, func(i, j int) bool {
+			return latest(out[i]) > latest(out[j])
+		})
+	case "name":
+		sort.Strings(out)
+	}
+	return out
 }
 
-func fetchData(title string, cutoff float64, countries []string) (Dataset, error) {
-	url := fmt.Sprintf("https://raw.githubusercontent.com/CSSEGISandData/COVID-19/master/csse_covid_19_data/csse_covid_19_time_series/time_series_covid19_%s_global.csv", title)
-
-	var dataset = Dataset{
-		table:  make(map[string][]float64, len(countries)),
-		cutoff: make(map[string]int, len(countries)),
+// cutoffLabel returns the label of an x-axis counting the days from the
+// first day the title dataset reached cutoff.
+func cutoffLabel(title string, cutoff float64) string {
+	if title == "cfr" {
+		// the ratio is aligned on the confirmed cases.
+		title = "confirmed"
 	}
+	name, ok := metricNames[title]
+	if !ok {
+		name = title
+	}
+	return fmt.Sprintf("Days from first %s %s", strconv.FormatFloat(cutoff, 'f', -1, 64), name)
+}
 
-	resp, err := http.Get(url)
-	if err != nil {
-		return dataset, fmt.Errorf("could not retrieve data file: %w", err)
+// dateTicks returns the ticks of an x-axis counting the days from start,
+// labeled with calendar dates.
+func dateTicks(start time.Time) plot.Ticker {
+	return plot.TimeTicks{
+		Ticker: hplot.Ticks{N: 10},
+		Format: "2006-01-02",
+		Time: func(x float64) time.Time {
+			return start.Add(time.Duration(x * 24 * float64(time.Hour)))
+		},
 	}
-	defer resp.Body.Close()
+}
 
-	raw := csv.NewReader(resp.Body)
-	raw.Comma = ','
+// growthLine is the exponential fit of the recent days of a series.
+type growthLine struct {
+	line   *plotter.Line
+	growth float64 // fitted daily growth rate
+}
 
-	hdr, err := raw.Read()
+// fitLine fits the last window points of a series to an exponential,
+// and returns a faint line of color c drawing the fit over that window.
+// fitLine reports whether the series had enough points for the fit.
+func fitLine(xs, ys []float64, window int, c color.Color) (growthLine, bool, error) {
+	alpha, beta, ok := growthFit(xs, ys, window)
+	if !ok {
+		return growthLine{}, false, nil
+	}
+	fxs := xs[max(0, len(xs)-window):]
+	fys := make([]float64, len(fxs))
+	for i, x := range fxs {
+		fys[i] = math.Exp(alpha + beta*x)
+	}
+	line, err := hplot.NewLine(hplot.ZipXY(fxs, fys))
 	if err != nil {
-		return dataset, fmt.Errorf("could not read CSV header: %w", err)
+		return growthLine{}, false, err
 	}
+	faint := color.NRGBAModel.Convert(c).(color.NRGBA)
+	faint.A = 0x80
+	line.Color = faint
+	line.Width = 6
+	return growthLine{line: line, growth: math.Exp(beta) - 1}, true, nil
+}
 
-	sz := len(hdr) - 4
-	for _, name := range countries {
-		dataset.table[name] = make([]float64, sz)
+// doublingWindow is the number of days the doubling times are estimated over.
+const doublingWindow = 7
+
+// formatDoubling formats a doubling time expressed in days.
+func formatDoubling(v float64) string {
+	switch {
+	case math.IsNaN(v):
+		return "—"
+	case math.IsInf(v, +1):
+		return "∞"
+	default:
+		return fmt.Sprintf("%.1fd", v)
 	}
+}
 
-loop:
-	for {
-		rec, err := raw.Read()
-		if err != nil {
-			if err == io.EOF {
-				break loop
-			}
-			return dataset, fmt.Errorf("could not read CSV data: %w", err)
-		}
+// herdImmunity returns the fraction of the population that needs to be
+// immune to stop the spread of a disease with a basic reproduction number r0.
+func herdImmunity(r0 float64) float64 {
+	return 1 - 1/r0
+}
 
-		if _, ok := dataset.table[rec[1]]; !ok {
+// finite returns the (x,y) pairs for which y is a finite value.
+// Dropped data points are represented as NaNs.
+func finite(xs, ys []float64) ([]float64, []float64) {
+	var (
+		oxs = make([]float64, 0, len(xs))
+		oys = make([]float64, 0, len(ys))
+	)
+	for i, y := range ys {
+		if math.IsNaN(y) || math.IsInf(y, 0) {
 			continue
 		}
-
-		name := rec[1]
-		rec = rec[4:]
-		data := make([]float64, len(rec))
-		for i, str := range rec {
-			if str == "" {
-				continue
-			}
-			v, err := strconv.ParseFloat(str, 64)
-			if err != nil {
-				return dataset, fmt.Errorf("could not parse %q: %w", str, err)
-			}
-			data[i] = v
-		}
-		floats.Add(dataset.table[name], data)
+		oxs = append(oxs, xs[i])
+		oys = append(oys, y)
 	}
+	return oxs, oys
+}
 
-	for _, name := range countries {
-		data := dataset.table[name]
-		idx := 0
-	cleanup:
-		for i, v := range data {
-			if v >= cutoff {
-				idx = i
-				dataset.cutoff[name] = idx
-				break cleanup
-			}
+// positive returns the (x,y) pairs for which y is strictly positive,
+// the only values a log scale can display.
+func positive(xs, ys []float64) ([]float64, []float64) {
+	var (
+		oxs = make([]float64, 0, len(xs))
+		oys = make([]float64, 0, len(ys))
+	)
+	for i, y := range ys {
+		if y <= 0 {
+			continue
 		}
-		dataset.table[name] = data[idx:]
+		oxs = append(oxs, xs[i])
+		oys = append(oys, y)
 	}
+	return oxs, oys
+}
 
-	const layout = "1/2/06"
-	for _, v := range []struct {
-		input  string
-		output *time.Time
-	}{
-		{hdr[4], &dataset.start},
-		{hdr[len(hdr)-1], &dataset.date},
-	} {
-		date, err := time.Parse(layout, v.input)
-		if err != nil {
-			return dataset, fmt.Errorf("could not parse date: %w", err)
-		}
-		*v.output = date
+// vlineLabel draws a text label rotated along a vertical line,
+// near the top of the plot.
+type vlineLabel struct {
+	X     float64
+	Text  string
+	Style draw.TextStyle
+}
+
+// Plot implements the plot.Plotter interface.
+func (lbl *vlineLabel) Plot(c draw.Canvas, plt *plot.Plot) {
+	trX, _ := plt.Transforms(&c)
+	x := trX(lbl.X)
+	if !c.ContainsX(x) {
+		return
 	}
 
-	cleanup(title, &dataset)
+	const pad = 2 * vg.Millimeter
+	sty := lbl.Style
+	sty.Rotation = math.Pi / 2
+	sty.XAlign = draw.XRight
+	// text is drawn on the left-hand side of the line,
+	// unless that would clip it against the left edge of the plot.
+	sty.YAlign = draw.YBottom
+	if x-sty.Height(lbl.Text)-pad < c.Min.X {
+		sty.YAlign = draw.YTop
+	}
+	c.FillText(sty, vg.Point{X: x, Y: c.Max.Y - pad}, lbl.Text)
+}
 
-	return dataset, nil
+// span fills the vertical band between X0 and X1.
+type span struct {
+	X0, X1 float64
+	Color  color.Color
 }
 
-func cleanup(title string, ds *Dataset) {
-	switch title {
-	case "Deaths":
-		tbl := ds.table["France"]
-		tbl[2] = 30   // 2020-03-09
-		tbl[10] = 175 // 2020-03-17
-		tbl[11] = 244 // 2020-03-18
-		tbl[12] = 372 // 2020-03-19
-	case "Confirmed":
+// Plot implements the plot.Plotter interface.
+func (s *span) Plot(c draw.Canvas, plt *plot.Plot) {
+	trX, _ := plt.Transforms(&c)
+	x0 := trX(s.X0)
+	x1 := trX(s.X1)
+	if x0 < c.Min.X {
+		x0 = c.Min.X
+	}
+	if x1 > c.Max.X {
+		x1 = c.Max.X
+	}
+	if x1 <= x0 {
+		return
 	}
+	c.SetColor(s.Color)
+	rect := vg.Rectangle{
+		Min: vg.Point{X: x0, Y: c.Min.Y},
+		Max: vg.Point{X: x1, Y: c.Max.Y},
+	}
+	c.Fill(rect.Path())
 }
 
 var (
-	lockDB = map[string]time.Time{
-		"Italy":  time.Date(2020, 2, 27, 0, 0, 0, 0, time.UTC), // lockdown of northern regions
-		"France": time.Date(2020, 3, 17, 0, 0, 0, 0, time.UTC),
+	// snapshotDir is the directory where snapshots of the plots are
+	// written, if any.
+	snapshotDir string
+
+	// sqlDriver is the name of the database/sql driver of the SQLite
+	// databases the series may be persisted to. It is empty in builds
+	// with the nosqlite tag, which leave the driver out.
+	sqlDriver string
+
+	// defaultCountries holds the countries displayed when none are requested.
+	defaultCountries = []string{
+		"France",
+		"Italy",
+		"Spain",
+		//	"Korea, South",
+		//	"China",
+		"Germany",
+		"US",
+		"United Kingdom",
+	}
+
+	// defaultLevel is the geographic level used when none is requested.
+	defaultLevel = "country"
+
+	// defaultStates holds the US states displayed when none are requested.
+	defaultStates = []string{
+		"New York",
+		"New Jersey",
+		"California",
+		"Washington",
+		"Texas",
+		"Florida",
+	}
+
+	// defaultCounties holds the US counties displayed when none are requested.
+	defaultCounties = []string{
+		"New York (New York)",
+		"Los Angeles (California)",
+		"Cook (Illinois)",
+		"Miami-Dade (Florida)",
+		"Harris (Texas)",
+		"King (Washington)",
+	}
+
+	// cutoffs holds the default cutoff of each dataset.
+	cutoffs = map[string]float64{
+		"confirmed": 100,
+		"deaths":    10,
+		"recovered": 100,
+		"active":    100,
+		"cfr":       100, // confirmed cases
+	}
+
+	// metricNames holds the human-readable name of the quantity of each dataset.
+	metricNames = map[string]string{
+		"confirmed": "confirmed cases",
+		"deaths":    "deaths",
+		"recovered": "recovered cases",
+		"active":    "active cases",
+		"cfr":       "case fatality ratio",
 	}
 )
 
-const page = `<!DOCTYPE html>
+// page holds the content of the index page.
+type page struct {
+	Plots []pagePlot
+
+	// current values of the form fields.
+	Level     string
+	Countries []string
+	Daily     bool
+	Scale     string
+
+	Hidden [][2]string // other query parameters, as (name, value) pairs
+}
+
+// pagePlot is a plot of the index page.
+type pagePlot struct {
+	Title string
+	URL   string
+}
+
+var pageTmpl = template.Must(template.New("page").Parse(`<!DOCTYPE html>
 <html>
 	<head>
 		<title>COVID-19</title>
 	</head>
 	<body>
+		<form id="options" action="/" method="get">
+			<select id="countries" multiple size="10"></select>
+			<input type="hidden" name="countries" value=""/>
+			<label><input type="checkbox" name="diff" value="1"{{if .Daily}} checked{{end}}/> daily</label>
+			<select name="scale">
+				<option value="log"{{if eq .Scale "log"}} selected{{end}}>log</option>
+				<option value="linear"{{if eq .Scale "linear"}} selected{{end}}>linear</option>
+			</select>
+			{{- range .Hidden}}
+			<input type="hidden" name="{{index . 0}}" value="{{index . 1}}"/>
+			{{- end}}
+			<input type="submit" value="Plot"/>
+		</form>
 		<div id="content">
-			<img id="plot" src="/img-confirmed"/>
-			<img id="plot" src="/img-deaths"/>
+			{{- range .Plots}}
+			<img id="plot-{{.Title}}" src="{{.URL}}"/>
+			{{- end}}
 		</div>
+		<script>
+			const selected = new Set({{.Countries}});
+			const list = document.getElementById("countries");
+			fetch("/countries?level=" + encodeURIComponent({{.Level}}))
+				.then(resp => resp.json())
+				.then(names => {
+					for (const name of names) {
+						list.add(new Option(name, name, false, selected.has(name)));
+					}
+				});
+			document.getElementById("options").addEventListener("submit", ev => {
+				const names = Array.from(list.selectedOptions, opt => opt.value);
+				const field = ev.target.elements.namedItem("countries");
+				if (names.length == 0) {
+					field.disabled = true;
+					return;
+				}
+				field.value = names.join(",");
+			});
+		</script>
 	</body>
 </html>
-`
+`))
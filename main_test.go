@@ -0,0 +1,261 @@
+// Copyright 2020 The covid19 Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"go-hep.org/x/hep/hplot"
+	"gonum.org/v1/plot/plotutil"
+	"gonum.org/v1/plot/vg/draw"
+)
+
+// withData serves the data files of testdata in place of the CSSE
+// repository for the duration of the test.
+func withData(t *testing.T) {
+	t.Helper()
+
+	orig := http.DefaultTransport
+	http.DefaultTransport = fixtures{}
+	t.Cleanup(func() { http.DefaultTransport = orig })
+}
+
+// fixtures is an HTTP transport replying with the file of testdata
+// named after the last element of the requested path.
+type fixtures struct{}
+
+func (fixtures) RoundTrip(req *http.Request) (*http.Response, error) {
+	f, err := os.Open(filepath.Join("testdata", path.Base(req.URL.Path)))
+	if err != nil {
+		return nil, err
+	}
+	return &http.Response{
+		Status:     "200 OK",
+		StatusCode: http.StatusOK,
+		Header:     make(http.Header),
+		Body:       f,
+		Request:    req,
+	}, nil
+}
+
+func TestGenImage(t *testing.T) {
+	withData(t)
+
+	req := httptest.NewRequest("GET", "/img-confirmed?countries=France", nil)
+	opts, err := parseOptions(req)
+	if err != nil {
+		t.Fatalf("could not parse options: %+v", err)
+	}
+
+	fig, err := genImage(context.Background(), "confirmed", 100, opts)
+	if err != nil {
+		t.Fatalf("could not generate plot: %+v", err)
+	}
+
+	raw, err := render(fig, opts)
+	if err != nil {
+		t.Fatalf("could not render plot: %+v", err)
+	}
+	img, err := png.Decode(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatalf("could not decode PNG image: %+v", err)
+	}
+
+	// the fixture runs past the lockdown of France, drawn as a vertical
+	// line with the color of its curve.
+	if !hasVLine(img, plotutil.SoftColors[0]) {
+		t.Fatalf("lockdown of France not drawn")
+	}
+}
+
+func TestGenImageDailyIndex(t *testing.T) {
+	withData(t)
+
+	for _, query := range []string{
+		"countries=France,Italy&diff=1&index=100",
+		"countries=France,Italy&diff=1&index=100&align=date",
+	} {
+		t.Run(query, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/img-confirmed?"+query, nil)
+			opts, err := parseOptions(req)
+			if err != nil {
+				t.Fatalf("could not parse options: %+v", err)
+			}
+
+			fig, err := genImage(context.Background(), "confirmed", 100, opts)
+			if err != nil {
+				t.Fatalf("could not generate plot: %+v", err)
+			}
+
+			// the daily new value of the cutoff day is not zero, and
+			// the series can be indexed to it.
+			p, ok := fig.drawer.(*hplot.Plot)
+			if !ok {
+				t.Fatalf("invalid plot type %T", fig.drawer)
+			}
+			if r := p.Legend.Rectangle(draw.Canvas{}); r.Size().Y <= 0 {
+				t.Fatalf("empty plot")
+			}
+		})
+	}
+}
+
+// hasVLine reports whether img displays a vertical line of color c,
+// possibly dashed, over at least a quarter of its height.
+// The pixels of the line may be blended with the grid drawn over it.
+func hasVLine(img image.Image, c color.Color) bool {
+	bnd := img.Bounds()
+	for x := bnd.Min.X; x < bnd.Max.X; x++ {
+		n := 0
+		for y := bnd.Min.Y; y < bnd.Max.Y; y++ {
+			if closeColor(img.At(x, y), c) {
+				n++
+			}
+		}
+		if n > bnd.Dy()/4 {
+			return true
+		}
+	}
+	return false
+}
+
+// closeColor reports whether the channels of colors a and b differ by
+// less than 1/16 of their range.
+func closeColor(a, b color.Color) bool {
+	const tol = 0x1000
+	r1, g1, b1, _ := a.RGBA()
+	r2, g2, b2, _ := b.RGBA()
+	for _, d := range []int{int(r1) - int(r2), int(g1) - int(g2), int(b1) - int(b2)} {
+		if d < -tol || d > tol {
+			return false
+		}
+	}
+	return true
+}
+
+func TestCutoffLabel(t *testing.T) {
+	for _, tc := range []struct {
+		title  string
+		cutoff float64
+		want   string
+	}{
+		{"confirmed", 100, "Days from first 100 confirmed cases"},
+		{"deaths", 10, "Days from first 10 deaths"},
+		{"recovered", 100, "Days from first 100 recovered cases"},
+		{"active", 100, "Days from first 100 active cases"},
+		{"cfr", 100, "Days from first 100 confirmed cases"},
+		{"deaths", 0.5, "Days from first 0.5 deaths"},
+		{"other", 1, "Days from first 1 other"},
+	} {
+		t.Run(tc.title, func(t *testing.T) {
+			if got := cutoffLabel(tc.title, tc.cutoff); got != tc.want {
+				t.Fatalf("invalid label: got=%q, want=%q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestImgHandleInvalidFormat(t *testing.T) {
+	withData(t)
+
+	req := httptest.NewRequest("GET", "/img-confirmed?format=bogus", nil)
+	w := httptest.NewRecorder()
+	imgHandle("confirmed", 100)(w, req)
+
+	if got, want := w.Code, http.StatusBadRequest; got != want {
+		t.Fatalf("invalid status code: got=%d, want=%d", got, want)
+	}
+	body := w.Body.String()
+	for _, format := range formats() {
+		if !strings.Contains(body, format) {
+			t.Fatalf("format %q missing from response:\n%s", format, body)
+		}
+	}
+}
+
+func TestParseOptionsPerAggregate(t *testing.T) {
+	for _, tc := range []struct {
+		query string
+		err   bool
+	}{
+		{"countries=France,Italy&per=1000000", false},
+		{"countries=EU27,US", false},
+		{"countries=EU27,US&per=1000000", true},
+		{"countries=France&ratioTo=World&per=1000000", true},
+	} {
+		t.Run(tc.query, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/img-confirmed?"+tc.query, nil)
+			_, err := parseOptions(req)
+			if got := err != nil; got != tc.err {
+				t.Fatalf("invalid error: got=%v, want error=%v", err, tc.err)
+			}
+		})
+	}
+}
+
+func TestCSVHandle(t *testing.T) {
+	withData(t)
+
+	// Monaco never reached the cutoff.
+	req := httptest.NewRequest("GET", "/csv-confirmed?countries=France,Monaco", nil)
+	w := httptest.NewRecorder()
+	csvHandle("confirmed", 100)(w, req)
+
+	if got, want := w.Code, http.StatusOK; got != want {
+		t.Fatalf("invalid status code: got=%d, want=%d", got, want)
+	}
+	got := w.Body.String()
+	want := `day,France
+0,131
+1,181
+2,282
+3,424
+4,656
+5,959
+6,1212
+7,1800
+8,2308
+9,2895
+10,3705
+11,4540
+12,4580
+13,5505
+14,6730
+15,7760
+16,9160
+17,11010
+`
+	if got != want {
+		t.Fatalf("invalid CSV table:\ngot:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestCompareTitle(t *testing.T) {
+	for _, tc := range []struct {
+		scale  string
+		cutoff float64
+		want   string
+	}{
+		{"log", 100, "CoVid-19 - deaths - log scale, cutoff=100"},
+		{"linear", 1000, "CoVid-19 - deaths - linear scale, cutoff=1000"},
+		{"log", 0.5, "CoVid-19 - deaths - log scale, cutoff=0.5"},
+		{"linear", 12.25, "CoVid-19 - deaths - linear scale, cutoff=12.25"},
+	} {
+		if got := compareTitle("deaths", tc.scale, tc.cutoff); got != tc.want {
+			t.Fatalf("invalid title: got=%q, want=%q", got, tc.want)
+		}
+	}
+}
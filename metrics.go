@@ -0,0 +1,177 @@
+// Copyright 2020 The covid19 Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/sbinet/covid19/internal/logctx"
+)
+
+// srvMetrics holds the metrics of the server.
+var srvMetrics = newMetrics()
+
+// metrics collects the counters of the server, exposed in the Prometheus
+// text exposition format.
+type metrics struct {
+	mu sync.Mutex
+
+	requests  map[[2]string]float64 // number of requests, by handler and status code
+	cache     map[[2]string]float64 // number of cache lookups, by key and result
+	fetches   map[[2]string]float64 // number of data downloads, by key and result
+	latency   map[string]float64    // total duration of successful downloads, by key
+	latencyN  map[string]float64    // number of successful downloads, by key
+	lastFetch map[string]time.Time  // time of the last successful download, by key
+}
+
+func newMetrics() *metrics {
+	return &metrics{
+		requests:  make(map[[2]string]float64),
+		cache:     make(map[[2]string]float64),
+		fetches:   make(map[[2]string]float64),
+		latency:   make(map[string]float64),
+		latencyN:  make(map[string]float64),
+		lastFetch: make(map[string]time.Time),
+	}
+}
+
+// request records a request served by handler with the given status code.
+func (m *metrics) request(handler string, code int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.requests[[2]string{handler, strconv.Itoa(code)}]++
+}
+
+// Lookup records a lookup of key in the data cache, with result one of
+// "hit", "stale" or "miss".
+func (m *metrics) Lookup(key, result string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.cache[[2]string{key, result}]++
+}
+
+// Fetch records a download of the data stored under key.
+func (m *metrics) Fetch(key string, start time.Time, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err != nil {
+		m.fetches[[2]string{key, "error"}]++
+		return
+	}
+	m.fetches[[2]string{key, "success"}]++
+	m.latency[key] += time.Since(start).Seconds()
+	m.latencyN[key]++
+	m.lastFetch[key] = time.Now()
+}
+
+// writeTo writes the metrics to w in the Prometheus text format.
+func (m *metrics) writeTo(w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	labeled := func(name, help, kind string, vs map[[2]string]float64, k0, k1 string) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+		keys := make([][2]string, 0, len(vs))
+		for k := range vs {
+			keys = append(keys, k)
+		}
+		sort.Slice(keys, func(i, j int) bool {
+			if keys[i][0] != keys[j][0] {
+				return keys[i][0] < keys[j][0]
+			}
+			return keys[i][1] < keys[j][1]
+		})
+		for _, k := range keys {
+			fmt.Fprintf(w, "%s{%s=%q,%s=%q} %g\n", name, k0, k[0], k1, k[1], vs[k])
+		}
+	}
+	single := func(name string, vs map[string]float64, k0 string) {
+		keys := make([]string, 0, len(vs))
+		for k := range vs {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			fmt.Fprintf(w, "%s{%s=%q} %g\n", name, k0, k, vs[k])
+		}
+	}
+
+	labeled(
+		"covid19_http_requests_total", "Number of HTTP requests served.", "counter",
+		m.requests, "handler", "code",
+	)
+	labeled(
+		"covid19_cache_lookups_total", "Number of lookups in the data cache.", "counter",
+		m.cache, "key", "result",
+	)
+	labeled(
+		"covid19_fetches_total", "Number of downloads from the data source.", "counter",
+		m.fetches, "key", "result",
+	)
+
+	const latency = "covid19_fetch_duration_seconds"
+	fmt.Fprintf(w, "# HELP %s Duration of the successful downloads.\n# TYPE %s summary\n", latency, latency)
+	single(latency+"_sum", m.latency, "key")
+	single(latency+"_count", m.latencyN, "key")
+
+	const last = "covid19_last_fetch_timestamp_seconds"
+	fmt.Fprintf(w, "# HELP %s Time of the last successful download.\n# TYPE %s gauge\n", last, last)
+	stamps := make(map[string]float64, len(m.lastFetch))
+	for k, t := range m.lastFetch {
+		stamps[k] = float64(t.Unix())
+	}
+	single(last, stamps, "key")
+}
+
+// metricsHandle serves the metrics of the server.
+func metricsHandle(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	srvMetrics.writeTo(w)
+}
+
+// requestTimeout bounds the time spent serving a request, if positive.
+var requestTimeout = 1 * time.Minute
+
+// instrument wraps h so the requests it serves are counted under name.
+// Each request is also given an ID, returned in the X-Request-ID header,
+// which tags the records of the logger of the request context, and a
+// deadline of requestTimeout.
+func instrument(name string, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		id := newRequestID()
+		w.Header().Set("X-Request-ID", id)
+		l := slog.Default().With("req", id, "path", req.URL.Path, "query", req.URL.RawQuery)
+		ctx := logctx.With(req.Context(), l)
+		if requestTimeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, requestTimeout)
+			defer cancel()
+		}
+		req = req.WithContext(ctx)
+
+		rec := &statusRecorder{ResponseWriter: w, code: http.StatusOK}
+		h(rec, req)
+		srvMetrics.request(name, rec.code)
+	}
+}
+
+// statusRecorder records the status code of a response.
+type statusRecorder struct {
+	http.ResponseWriter
+	code int
+}
+
+func (rec *statusRecorder) WriteHeader(code int) {
+	rec.code = code
+	rec.ResponseWriter.WriteHeader(code)
+}
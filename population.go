@@ -0,0 +1,46 @@
+// Copyright 2020 The covid19 Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"net/http"
+)
+
+// perMillionHandle serves the deaths of the requested countries per
+// million people, the usual way of comparing mortality between countries.
+func perMillionHandle() http.HandlerFunc {
+	h := imgHandle("deaths", cutoffs["deaths"])
+	return func(w http.ResponseWriter, req *http.Request) {
+		q := req.URL.Query()
+		q.Set("per", "1000000")
+		r := req.Clone(req.Context())
+		r.URL.RawQuery = q.Encode()
+		h(w, r)
+	}
+}
+
+// cfrHandle serves the case fatality ratio of the requested countries,
+// the deaths over the confirmed cases. The ratio is plotted on a linear
+// scale unless requested otherwise.
+func cfrHandle() http.HandlerFunc {
+	h := imgHandle("cfr", cutoffs["cfr"])
+	return func(w http.ResponseWriter, req *http.Request) {
+		q := req.URL.Query()
+		if !q.Has("scale") {
+			q.Set("scale", "linear")
+		}
+		r := req.Clone(req.Context())
+		r.URL.RawQuery = q.Encode()
+		h(w, r)
+	}
+}
+
+// perLabel returns the unit of values normalized to base people.
+func perLabel(base float64) string {
+	if base == 1e6 {
+		return "million"
+	}
+	return formatCount(base) + " people"
+}
@@ -0,0 +1,76 @@
+// Copyright 2020 The covid19 Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/sbinet/covid19/data"
+	"github.com/sbinet/covid19/internal/logctx"
+	"go-hep.org/x/hep/hplot"
+)
+
+// genSelfCompare creates a plot of the daily new values of a single
+// country, split into waves at the requested dates and overlaid so
+// that each wave starts at day 0.
+func genSelfCompare(ctx context.Context, title string, cutoff float64, opts Options) (*hplot.Plot, error) {
+	name := opts.SelfCompare
+	ds, err := data.Fetch(ctx, title, 0, []string{name}, opts.Options)
+	if err != nil {
+		return nil, fmt.Errorf("could not fetch data: %w", err)
+	}
+	logctx.From(ctx).Info("data for", "title", title, "date", ds.Date.Format("2006-01-02"))
+
+	// the daily new values are computed before the series is trimmed at
+	// the cutoff, so that of its first day is not a spurious zero.
+	news := daily(ds.Table[name])
+	ds.Align(cutoff)
+	news = news[len(news)-len(ds.Table[name]):]
+	if len(news) == 0 {
+		return nil, fmt.Errorf("no data for %q", name)
+	}
+
+	// indices in news at which each wave begins.
+	idx := []int{0}
+	for _, date := range opts.Waves {
+		i := int(ds.Day(name, date))
+		if i <= idx[len(idx)-1] || i >= len(news) {
+			return nil, fmt.Errorf(
+				"wave split %s out of the data range of %q",
+				date.Format("2006-01-02"), name,
+			)
+		}
+		idx = append(idx, i)
+	}
+	idx = append(idx, len(news))
+
+	th := opts.theme()
+	p := hplot.New()
+	th.apply(p)
+	p.Title.Text = "CoVid-19 - " + title + " - " + name + " - " + ds.Date.Format("2006-01-02")
+	p.X.Label.Text = "Days from wave start"
+	p.X.Tick.Marker = hplot.Ticks{N: 20}
+	p.Y.Label.Text = "daily new " + title
+
+	for i := range idx[:len(idx)-1] {
+		ys := news[idx[i]:idx[i+1]]
+		xs := make([]float64, len(ys))
+		for j := range xs {
+			xs[j] = float64(j)
+		}
+		line, err := hplot.NewLine(hplot.ZipXY(finite(xs, ys)))
+		if err != nil {
+			return nil, fmt.Errorf("could not create line plot for wave %d: %w", i+1, err)
+		}
+		line.Color = th.color(i)
+		line.Width = 2
+		p.Add(line)
+		p.Legend.Add(fmt.Sprintf("wave %d", i+1), line)
+	}
+	p.Add(th.grid())
+
+	return p, nil
+}
@@ -0,0 +1,106 @@
+// Copyright 2020 The covid19 Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/sbinet/covid19/internal/logctx"
+)
+
+// snapshotHandle writes a snapshot of the plots of titles to the
+// -snapshot-dir directory, drawn with the plotting options of the
+// request, and replies with the names of the written files.
+func snapshotHandle(titles []string) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		// a snapshot writes files: it is not a safe request.
+		if req.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		ctx := req.Context()
+		opts, err := parseOptions(req)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		fnames, err := snapshot(ctx, titles, opts, time.Now())
+		if err != nil {
+			logctx.From(ctx).Error("could not take snapshot", "error", err)
+			http.Error(w, err.Error(), errStatus(err))
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		for _, fname := range fnames {
+			fmt.Fprintln(w, fname)
+		}
+	}
+}
+
+// snapshot renders the plots of titles and writes them to the
+// -snapshot-dir directory, named after the dataset and the time t.
+// snapshot returns the names of the written files.
+func snapshot(ctx context.Context, titles []string, opts Options, t time.Time) ([]string, error) {
+	stamp := t.UTC().Format("20060102T150405Z")
+	fnames := make([]string, 0, len(titles))
+	for _, title := range titles {
+		fig, err := genImage(ctx, title, cutoffs[title], opts)
+		if err != nil {
+			return fnames, fmt.Errorf("could not generate %s plot: %w", title, err)
+		}
+		raw, err := render(fig, opts)
+		if err != nil {
+			return fnames, err
+		}
+
+		fname := filepath.Join(snapshotDir, "covid-"+title+"-"+stamp+"."+opts.Format)
+		err = writeFile(fname, raw)
+		if err != nil {
+			return fnames, err
+		}
+		logctx.From(ctx).Info("snapshot written", "title", title, "file", fname)
+		fnames = append(fnames, fname)
+	}
+	return fnames, nil
+}
+
+// writeFile writes raw to the file fname.
+// The content is written to a temporary file first, so concurrent
+// readers and writers never see a partial file.
+func writeFile(fname string, raw []byte) error {
+	f, err := os.CreateTemp(filepath.Dir(fname), filepath.Base(fname)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("could not create temporary file: %w", err)
+	}
+	defer os.Remove(f.Name())
+
+	_, err = f.Write(raw)
+	if err == nil {
+		err = f.Chmod(0644)
+	}
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("could not write file: %w", err)
+	}
+	err = f.Close()
+	if err != nil {
+		return fmt.Errorf("could not close file: %w", err)
+	}
+
+	err = os.Rename(f.Name(), fname)
+	if err != nil {
+		return fmt.Errorf("could not rename file: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,11 @@
+// Copyright 2020 The covid19 Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !nosqlite
+
+package main
+
+import _ "modernc.org/sqlite" // registers the "sqlite" database/sql driver.
+
+func init() { sqlDriver = "sqlite" }
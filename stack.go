@@ -0,0 +1,111 @@
+// Copyright 2020 The covid19 Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/sbinet/covid19/internal/logctx"
+	"github.com/sbinet/covid19/internal/parallel"
+	"gonum.org/v1/plot/vg"
+	"gonum.org/v1/plot/vg/draw"
+)
+
+// stackHandle serves the plots of several datasets stacked vertically
+// into a single image, confirmed cases and deaths by default.
+func stackHandle(w http.ResponseWriter, req *http.Request) {
+	ctx := req.Context()
+	titles := []string{"confirmed", "deaths"}
+	if v := req.FormValue("titles"); v != "" {
+		titles = nil
+		for _, title := range strings.Split(v, ",") {
+			title = strings.TrimSpace(title)
+			if _, ok := cutoffs[title]; !ok {
+				imageError(w, fmt.Sprintf("invalid title %q", title))
+				return
+			}
+			titles = append(titles, title)
+		}
+	}
+
+	opts, err := parseOptions(req)
+	if err != nil {
+		optionsError(w, err)
+		return
+	}
+
+	// the image changes with the latest of the datasets.
+	var date time.Time
+	for _, title := range titles {
+		v, err := dataDate(ctx, title, opts)
+		if err != nil {
+			date = time.Time{}
+			break
+		}
+		if v.After(date) {
+			date = v
+		}
+	}
+	if !date.IsZero() && notModified(w, req, date, opts.Format) {
+		return
+	}
+
+	fig, err := genStack(ctx, titles, opts)
+	if err != nil {
+		logctx.From(ctx).Error("could not serve request", "error", err)
+		imageError(w, err.Error())
+		return
+	}
+
+	err = encodeImage(w, fig, opts)
+	if err != nil {
+		logctx.From(ctx).Error("could not serve request", "error", err)
+		imageError(w, err.Error())
+		return
+	}
+}
+
+// genStack stacks the plots of the titles datasets on top of each other.
+// Each plot keeps the size it has when served alone.
+func genStack(ctx context.Context, titles []string, opts Options) (figure, error) {
+	figs := make([]figure, len(titles))
+	gens := make([]func() error, len(titles))
+	for i, title := range titles {
+		i, title := i, title
+		gens[i] = func() error {
+			fig, err := genImage(ctx, title, cutoffs[title], opts)
+			if err != nil {
+				return fmt.Errorf("could not generate %q plot: %w", title, err)
+			}
+			figs[i] = fig
+			return nil
+		}
+	}
+	err := parallel.Do(gens...)
+	if err != nil {
+		return figure{}, err
+	}
+
+	var width, height vg.Length
+	for _, fig := range figs {
+		if fig.width > width {
+			width = fig.width
+		}
+		height += fig.height
+	}
+
+	tiles := draw.Tiles{Rows: len(figs), Cols: 1}
+	stack := func(c draw.Canvas) {
+		for i, fig := range figs {
+			fig.drawer.Draw(tiles.At(c, 0, i))
+		}
+	}
+
+	return figure{drawer: drawFunc(stack), width: width, height: height}, nil
+}
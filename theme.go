@@ -0,0 +1,82 @@
+// Copyright 2020 The covid19 Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"image/color"
+
+	"go-hep.org/x/hep/hplot"
+	"gonum.org/v1/plot"
+	"gonum.org/v1/plot/plotter"
+	"gonum.org/v1/plot/plotutil"
+	"gonum.org/v1/plot/vg/draw"
+)
+
+// theme holds the colors used to draw the plots.
+type theme struct {
+	Background color.Color
+	Foreground color.Color // text, axes and ticks
+	Grid       color.Color
+	Guide      color.Color // reference lines, such as the growth guidelines
+	Palette    []color.Color
+}
+
+var themes = map[string]theme{
+	"light": {
+		Background: color.White,
+		Foreground: color.Black,
+		Grid:       plotter.DefaultGridLineStyle.Color,
+		Guide:      color.Gray16{},
+		Palette:    plotutil.SoftColors,
+	},
+	"dark": {
+		Background: color.NRGBA{R: 30, G: 30, B: 30, A: 255},
+		Foreground: color.NRGBA{R: 220, G: 220, B: 220, A: 255},
+		Grid:       color.NRGBA{R: 80, G: 80, B: 80, A: 255},
+		Guide:      color.NRGBA{R: 235, G: 235, B: 235, A: 255},
+		Palette: []color.Color{
+			color.NRGBA{R: 255, G: 107, B: 107, A: 255},
+			color.NRGBA{R: 129, G: 236, B: 132, A: 255},
+			color.NRGBA{R: 102, G: 178, B: 255, A: 255},
+			color.NRGBA{R: 255, G: 193, B: 84, A: 255},
+			color.NRGBA{R: 200, G: 140, B: 255, A: 255},
+			color.NRGBA{R: 255, G: 145, B: 200, A: 255},
+			color.NRGBA{R: 100, G: 230, B: 220, A: 255},
+		},
+	},
+}
+
+// color returns the i-th color of the palette, cycling through the
+// palette when there are more lines than colors.
+func (th theme) color(i int) color.Color {
+	return th.Palette[i%len(th.Palette)]
+}
+
+// apply sets the background, text and axes colors of p.
+func (th theme) apply(p *hplot.Plot) {
+	p.BackgroundColor = th.Background
+	p.Title.TextStyle.Color = th.Foreground
+	p.Legend.TextStyle.Color = th.Foreground
+	for _, axis := range []*plot.Axis{&p.X, &p.Y} {
+		axis.Label.TextStyle.Color = th.Foreground
+		axis.LineStyle.Color = th.Foreground
+		axis.Tick.Label.Color = th.Foreground
+		axis.Tick.LineStyle.Color = th.Foreground
+	}
+}
+
+// grid returns a grid drawn with the colors of the theme.
+func (th theme) grid() *plotter.Grid {
+	grid := hplot.NewGrid()
+	grid.Vertical.Color = th.Grid
+	grid.Horizontal.Color = th.Grid
+	return grid
+}
+
+// fill paints the whole canvas with the background color.
+func (th theme) fill(c draw.Canvas) {
+	c.SetColor(th.Background)
+	c.Fill(c.Rectangle.Path())
+}
@@ -0,0 +1,84 @@
+// Copyright 2020 The covid19 Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/sbinet/covid19/data"
+	"github.com/sbinet/covid19/internal/logctx"
+	"go-hep.org/x/hep/hplot"
+	"gonum.org/v1/plot"
+)
+
+// genTrajectory creates a log-log plot of the new values of the last
+// week against the cumulative values, with one line per country.
+// Countries in exponential growth follow the same straight line, and
+// drop off it as the growth slows down, whatever their timing.
+func genTrajectory(ctx context.Context, title string, cutoff float64, opts Options) (*hplot.Plot, error) {
+	countries := opts.Countries
+	// the weekly values of the first days after the cutoff need the
+	// days before it.
+	ds, err := data.Fetch(ctx, title, 0, countries, opts.Options)
+	if err != nil {
+		return nil, fmt.Errorf("could not fetch data: %w", err)
+	}
+	logctx.From(ctx).Info("data for", "title", title, "date", ds.Date.Format("2006-01-02"))
+
+	prec := precCount
+	if opts.PerCapita > 0 {
+		countries = data.PerCapita(ctx, &ds, countries, opts.PerCapita)
+		prec = precRate
+	}
+
+	name := title
+	if v, ok := metricNames[title]; ok {
+		name = v
+	}
+	if opts.PerCapita > 0 {
+		name = fmt.Sprintf("%s per %s", name, perLabel(opts.PerCapita))
+	}
+
+	th := opts.theme()
+	p := hplot.New()
+	th.apply(p)
+	p.Title.Text = "CoVid-19 - " + title + " - " + ds.Date.Format("2006-01-02")
+	p.X.Label.Text = "total " + name
+	p.Y.Label.Text = "new " + name + " in the last week"
+	for _, axis := range []*plot.Axis{&p.X, &p.Y} {
+		axis.Scale = plot.LogScale{}
+		axis.Tick.Marker = plot.LogTicks{}
+	}
+
+	for i, country := range countries {
+		cumul := ds.Table[country]
+		news := weekly(cumul)
+		i0, ok := data.CutoffIndex(cumul, cutoff)
+		if !ok {
+			logctx.From(ctx).Warn("cutoff never reached, skipping", "title", title, "country", country, "cutoff", cutoff)
+			continue
+		}
+		xs, ys := positive(finite(cumul[i0:], news[i0:]))
+		ys, xs = finite(ys, xs) // dropped values are missing from both axes.
+		if len(xs) == 0 {
+			logctx.From(ctx).Warn("no valid data, skipping", "title", title, "country", country)
+			continue
+		}
+		line, err := hplot.NewLine(hplot.ZipXY(xs, ys))
+		if err != nil {
+			return nil, fmt.Errorf("could not create line plot for %q: %w", country, err)
+		}
+		line.Color = th.color(i)
+		line.Width = 2
+		p.Add(line)
+		p.Legend.Add(fmt.Sprintf("%s %8s", country, opts.format(news[len(news)-1], prec)), line)
+	}
+	p.Legend.Left = true
+	p.Legend.Top = true
+	p.Add(th.grid())
+
+	return p, nil
+}
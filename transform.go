@@ -0,0 +1,177 @@
+// Copyright 2020 The covid19 Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"math"
+
+	"gonum.org/v1/gonum/stat"
+)
+
+// daily returns the first difference of a cumulative series,
+// clamping negative corrections to zero.
+func daily(ys []float64) []float64 {
+	out := make([]float64, len(ys))
+	for i := range ys {
+		if i == 0 {
+			continue
+		}
+		v := ys[i] - ys[i-1]
+		if v < 0 || math.IsNaN(v) {
+			v = 0
+		}
+		out[i] = v
+	}
+	return out
+}
+
+// weekly returns the new values of a cumulative series over the 7 days
+// up to each day. The first days count the new values since the start
+// of the series.
+func weekly(ys []float64) []float64 {
+	const lag = 7
+	out := make([]float64, len(ys))
+	for i, v := range ys {
+		if i >= lag {
+			v -= ys[i-lag]
+		}
+		out[i] = math.Max(v, 0)
+	}
+	return out
+}
+
+// smooth returns the moving average of ys over a window of n days,
+// centered on each day or, when trailing, ending on it.
+// At the edges of the series, values are averaged over the available points.
+// Missing values (NaNs) are ignored.
+func smooth(ys []float64, n int, trailing bool) []float64 {
+	out := make([]float64, len(ys))
+	lo := (n - 1) / 2
+	hi := n - 1 - lo
+	if trailing {
+		lo, hi = n-1, 0
+	}
+	for i := range ys {
+		var (
+			sum float64
+			cnt int
+		)
+		for j := i - lo; j <= i+hi; j++ {
+			if j < 0 || j >= len(ys) || math.IsNaN(ys[j]) {
+				continue
+			}
+			sum += ys[j]
+			cnt++
+		}
+		if cnt == 0 {
+			out[i] = math.NaN()
+			continue
+		}
+		out[i] = sum / float64(cnt)
+	}
+	return out
+}
+
+// doublingTime returns the number of days the series takes to double,
+// estimated from its growth over the last window days.
+// doublingTime returns +Inf when the series is flat or decreasing, and
+// NaN when the series is too short or not positive.
+func doublingTime(ys []float64, window int) float64 {
+	if window < 1 || len(ys) <= window {
+		return math.NaN()
+	}
+	var (
+		y0 = ys[len(ys)-1-window]
+		y1 = ys[len(ys)-1]
+	)
+	if !(y0 > 0) || !(y1 > 0) || math.IsInf(y0, 0) || math.IsInf(y1, 0) {
+		return math.NaN()
+	}
+	if y1 <= y0 {
+		return math.Inf(+1)
+	}
+	return float64(window) * math.Ln2 / math.Log(y1/y0)
+}
+
+// growthFit fits the last window points of the series to an exponential,
+// with a least-squares fit of log(y) = alpha + beta*x.
+// Points with a non-positive value are excluded from the fit.
+// growthFit reports whether there were enough points to perform the fit.
+func growthFit(xs, ys []float64, window int) (alpha, beta float64, ok bool) {
+	if len(ys) > window {
+		xs = xs[len(xs)-window:]
+		ys = ys[len(ys)-window:]
+	}
+	var (
+		fx = make([]float64, 0, len(xs))
+		fy = make([]float64, 0, len(ys))
+	)
+	for i, y := range ys {
+		if !(y > 0) || math.IsInf(y, 0) {
+			continue
+		}
+		fx = append(fx, xs[i])
+		fy = append(fy, math.Log(y))
+	}
+	if len(fx) < 2 {
+		return 0, 0, false
+	}
+	alpha, beta = stat.LinearRegression(fx, fy, nil, false)
+	return alpha, beta, true
+}
+
+// change returns the change of ys compared to lag days before, in percent.
+// The values of the first lag days, which lack history, are missing (NaNs),
+// as are the changes from a zero value.
+func change(ys []float64, lag int) []float64 {
+	out := make([]float64, len(ys))
+	for i := range ys {
+		if i < lag || ys[i-lag] == 0 {
+			out[i] = math.NaN()
+			continue
+		}
+		out[i] = 100 * (ys[i]/ys[i-lag] - 1)
+	}
+	return out
+}
+
+// doublingTimes returns the doubling time of ys on each day, in days,
+// estimated from its growth over the previous window days.
+// The values of the first window days are missing (NaNs), and the days
+// without growth take an infinite doubling time.
+func doublingTimes(ys []float64, window int) []float64 {
+	out := make([]float64, len(ys))
+	for i := range ys {
+		out[i] = doublingTime(ys[:i+1], window)
+	}
+	return out
+}
+
+// ratio returns ys divided by ref, day by day, over the length of the
+// shorter series. Days where ref is zero are missing (NaNs).
+func ratio(ys, ref []float64) []float64 {
+	out := make([]float64, min(len(ys), len(ref)))
+	for i := range out {
+		if ref[i] == 0 {
+			out[i] = math.NaN()
+			continue
+		}
+		out[i] = ys[i] / ref[i]
+	}
+	return out
+}
+
+// rebase returns ys scaled so its i0-th value equals base, or false
+// when that value is zero (or missing) and can not be rebased.
+func rebase(ys []float64, i0 int, base float64) ([]float64, bool) {
+	if i0 >= len(ys) || ys[i0] == 0 || math.IsNaN(ys[i0]) {
+		return nil, false
+	}
+	out := make([]float64, len(ys))
+	for i, v := range ys {
+		out[i] = v * base / ys[i0]
+	}
+	return out, true
+}